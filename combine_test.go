@@ -0,0 +1,110 @@
+package main
+
+import (
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestCombinator(t *testing.T) {
+        c, err := newCombinator([]string{"line", "cl"})
+        if err != nil {
+                t.Fatal(err)
+        }
+        want := int64(len(clGadgets()) * len(requestLines()))
+        if c.size() != want {
+                t.Fatalf("got %d combinations, want %d", c.size(), want)
+        }
+        all := c.sample(0)
+        if all.size() != want {
+                t.Fatalf("budget 0 runs %d combinations, want %d", all.size(), want)
+        }
+        names := make(map[string]bool)
+        for k := int64(0); k < all.size(); k++ {
+                combo := all.at(k)
+                parts := strings.Split(combo.Name, "+")
+                if len(parts) != 2 || clGadgets()[parts[0]] == "" || requestLines()[parts[1]] == "" {
+                        t.Fatalf("combo %q is not named cl+line", combo.Name)
+                }
+                if names[combo.Name] {
+                        t.Fatalf("duplicate combo %s", combo.Name)
+                }
+                names[combo.Name] = true
+        }
+
+        keepRandom(t)
+        seedRandom(5)
+        first := c.sample(10)
+        seedRandom(5)
+        if again := c.sample(10); first.size() != 10 || !reflect.DeepEqual(first, again) {
+                t.Errorf("seeded budgets differ or have the wrong size")
+        }
+
+        if _, err := newCombinator([]string{"te", "bogus"}); err == nil {
+                t.Errorf("unknown family accepted")
+        }
+}
+
+// TestComboRunLazy checks running every combination of all four families
+// stacks them on demand rather than building the whole cross product.
+func TestComboRunLazy(t *testing.T) {
+        c, err := newCombinator(comboFamilies)
+        if err != nil {
+                t.Fatal(err)
+        }
+        named, err := parseCombo("tabprefix1+clDup")
+        if err != nil {
+                t.Fatal(err)
+        }
+        run := c.sample(0)
+        if run.indices != nil {
+                t.Fatalf("budget 0 materialised %d indices", len(run.indices))
+        }
+        run.named = append(run.named, named)
+        if run.size() != c.size()+1 {
+                t.Fatalf("got size %d, want %d", run.size(), c.size()+1)
+        }
+        if got, want := run.at(c.size()-1), c.at(c.size()-1); !reflect.DeepEqual(got, want) {
+                t.Errorf("last combination: got %s, want %s", got.Name, want.Name)
+        }
+        if got := run.at(c.size()); got.Name != "tabprefix1+clDup" {
+                t.Errorf("named combo: got %s, want it after the combinations", got.Name)
+        }
+}
+
+func TestParseCombo(t *testing.T) {
+        c, err := parseCombo("http10+clDup+tabprefix1")
+        if err != nil {
+                t.Fatal(err)
+        }
+        if c.Name != "tabprefix1+clDup+http10" {
+                t.Errorf("got name %q, want family order", c.Name)
+        }
+        if c.Gadget != teGadgets()["tabprefix1"] || c.CLGadget != clGadgets()["clDup"] || c.RequestLine != requestLines()["http10"] {
+                t.Errorf("combo members not applied: %+v", c)
+        }
+        if _, err := parseCombo("tabprefix1+space1"); err == nil {
+                t.Errorf("two TE mutations accepted")
+        }
+        if _, err := parseCombo("tabprefix1+nope"); err == nil {
+                t.Errorf("unknown mutation accepted")
+        }
+}
+
+// TestComboDetection runs stacked mutations end to end: only the stack as a
+// whole crosses the front-end's parser.
+func TestComboDetection(t *testing.T) {
+        strict := ParserConfig{HonourCL: true, HonourTE: true}
+        no10 := ParserConfig{HonourCL: true, HonourTE: true, NoTE10: true}
+        first := ParserConfig{HonourCL: true, HonourTE: true, CLPolicy: "first"}
+        last := ParserConfig{HonourCL: true, HonourTE: true, CLPolicy: "last"}
+        tests := []detectCase{
+                {"te+cl+line", no10, strict, "tabprefix1+clDup+http10", "CLTE tabprefix1+clDup+http10"},
+                {"cl+line", first, last, "clDup+absolute", "CLCL clDup+absolute"},
+                {"te+chunk", strict, strict, "tabprefix1+chunkBareLF", ""},
+        }
+        runDetectCases(t, tests, func(d *Desyncr, combo string) bool {
+                c, _ := parseCombo(combo)
+                return d.runCombos(&comboRun{named: []mutationCombo{c}})
+        })
+}
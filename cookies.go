@@ -0,0 +1,137 @@
+package main
+
+import (
+        "bufio"
+        "fmt"
+        "net/http"
+        "net/http/cookiejar"
+        "net/url"
+        "os"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// ------------------------------
+// Cookie jar
+
+// cookieOptions controls how the scan obtains cookies.
+type cookieOptions struct {
+        Seed    string // "name=value; name2=value2" from --cookie
+        File    string // Netscape cookies.txt or name=value lines
+        NoFetch bool   // skip the cookie fetch request
+}
+
+var cookieOpts cookieOptions
+
+// jarURL is the URL cookies are stored and looked up under: the Host header
+// name and the endpoint path, so domain and path attributes apply as they
+// would in a browser.
+func (d *Desyncr) jarURL() *url.URL {
+        scheme := "http"
+        if d.sslFlag {
+                scheme = "https"
+        }
+        path := d.endpoint
+        if idx := strings.Index(path, "?"); idx >= 0 {
+                path = path[:idx]
+        }
+        if path == "" {
+                path = "/"
+        }
+        return &url.URL{Scheme: scheme, Host: d.hostHeader(), Path: path}
+}
+
+// cookieHeader returns the Cookie header value for the target, or "".
+func (d *Desyncr) cookieHeader() string {
+        if d.jar == nil {
+                return ""
+        }
+        var pairs []string
+        for _, c := range d.jar.Cookies(d.jarURL()) {
+                pairs = append(pairs, c.Name+"="+c.Value)
+        }
+        return strings.Join(pairs, "; ")
+}
+
+// seedCookies creates the jar and fills it from --cookie and --cookie-file.
+func (d *Desyncr) seedCookies() error {
+        jar, err := cookiejar.New(nil)
+        if err != nil {
+                return err
+        }
+        d.jar = jar
+        target := d.jarURL()
+        if cookieOpts.Seed != "" {
+                cookies, err := http.ParseCookie(cookieOpts.Seed)
+                if err != nil {
+                        return fmt.Errorf("invalid --cookie value: %v", err)
+                }
+                jar.SetCookies(target, cookies)
+        }
+        if cookieOpts.File != "" {
+                return loadCookieFile(jar, target, cookieOpts.File)
+        }
+        return nil
+}
+
+// loadCookieFile reads a Netscape cookies.txt file, as written by curl and
+// browser extensions, or plain "name=value" lines. Plain lines apply to the
+// target only; Netscape entries keep their domain, path and secure flag.
+func loadCookieFile(jar http.CookieJar, target *url.URL, path string) error {
+        f, err := os.Open(path)
+        if err != nil {
+                return err
+        }
+        defer f.Close()
+        scanner := bufio.NewScanner(f)
+        for scanner.Scan() {
+                line := strings.TrimSpace(scanner.Text())
+                // curl marks HttpOnly cookies with a "#HttpOnly_" domain prefix.
+                line = strings.TrimPrefix(line, "#HttpOnly_")
+                if line == "" || strings.HasPrefix(line, "#") {
+                        continue
+                }
+                fields := strings.Split(line, "\t")
+                if len(fields) != 7 {
+                        cookies, err := http.ParseCookie(line)
+                        if err != nil {
+                                return fmt.Errorf("%s: %v", path, err)
+                        }
+                        jar.SetCookies(target, cookies)
+                        continue
+                }
+                domain := strings.TrimPrefix(fields[0], ".")
+                c := &http.Cookie{
+                        Name:   fields[5],
+                        Value:  fields[6],
+                        Path:   fields[2],
+                        Secure: strings.EqualFold(fields[3], "TRUE"),
+                }
+                if strings.EqualFold(fields[1], "TRUE") {
+                        c.Domain = domain
+                }
+                if exp, err := strconv.ParseInt(fields[4], 10, 64); err == nil && exp > 0 {
+                        c.Expires = time.Unix(exp, 0)
+                }
+                scheme := "http"
+                if c.Secure {
+                        scheme = "https"
+                }
+                jar.SetCookies(&url.URL{Scheme: scheme, Host: domain, Path: c.Path}, []*http.Cookie{c})
+        }
+        return scanner.Err()
+}
+
+// storeResponseCookies adds the Set-Cookie headers of a raw response to the
+// jar and returns how many were found.
+func (d *Desyncr) storeResponseCookies(raw string) int {
+        resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(raw)), nil)
+        if err != nil {
+                return 0
+        }
+        resp.Body.Close()
+        cookies := resp.Cookies()
+        d.jar.SetCookies(d.jarURL(), cookies)
+        return len(cookies)
+}
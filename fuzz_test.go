@@ -0,0 +1,96 @@
+package main
+
+import (
+        "path/filepath"
+        "reflect"
+        "testing"
+        "time"
+)
+
+func TestFuzzCandidates(t *testing.T) {
+        all := fuzzCandidates(0)
+        want := len(fuzzNames) * len(fuzzSeparators) * len(fuzzValues) * len(fuzzShapes)
+        if len(all) != want {
+                t.Fatalf("got %d candidates, want %d", len(all), want)
+        }
+        names := make(map[string]bool)
+        for _, spec := range all {
+                if names[spec.Name] {
+                        t.Fatalf("duplicate candidate name %s", spec.Name)
+                }
+                names[spec.Name] = true
+        }
+
+        keepRandom(t)
+        seedRandom(1)
+        first := fuzzCandidates(20)
+        seedRandom(1)
+        if again := fuzzCandidates(20); !reflect.DeepEqual(first, again) {
+                t.Errorf("seeded samples differ")
+        }
+}
+
+// TestFuzzEvaluate keeps only the generated gadget whose responses differ
+// from the baseline: the one the simulator's back-end tolerates and its
+// front-end does not.
+func TestFuzzEvaluate(t *testing.T) {
+        s, err := startSimulator(
+                ParserConfig{HonourCL: true, HonourTE: true},
+                ParserConfig{HonourCL: true, HonourTE: true, Tolerate: []string{"space1"}},
+        )
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer s.Close()
+        d := simDesyncr(s)
+        d.timeout = 500 * time.Millisecond
+
+        var specs []mutationSpec
+        for _, spec := range fuzzCandidates(0) {
+                if spec.Name == "fuzz-canon-spcol-chunked-single" || spec.Name == "fuzz-canon-colsp-chunked-single" {
+                        specs = append(specs, spec)
+                }
+        }
+        kept := d.evaluateGadgets(specs)
+        if len(kept) != 1 || kept[0].Gadget != teGadgets()["space1"] {
+                t.Errorf("got %v, want only the space1 gadget", kept)
+        }
+
+        presets := simulatorPresets["safe"]
+        safe, err := startSimulator(presets[0], presets[1])
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer safe.Close()
+        d = simDesyncr(safe)
+        d.timeout = 500 * time.Millisecond
+        if kept := d.evaluateGadgets(specs); len(kept) != 0 {
+                t.Errorf("safe simulator: got %v, want no differential gadgets", kept)
+        }
+}
+
+func TestDedupeSpecs(t *testing.T) {
+        specs := []mutationSpec{{"a", "1"}, {"b", "2"}, {"a", "1"}, {"c", "3"}, {"b", "2"}}
+        want := []mutationSpec{{"a", "1"}, {"b", "2"}, {"c", "3"}}
+        if got := dedupeSpecs(specs); !reflect.DeepEqual(got, want) {
+                t.Errorf("got %v, want %v", got, want)
+        }
+}
+
+func TestMutationFileRoundTrip(t *testing.T) {
+        var specs []mutationSpec
+        for name, gadget := range teGadgets() {
+                specs = append(specs, mutationSpec{Name: name, Gadget: gadget})
+        }
+        path := filepath.Join(t.TempDir(), "mutations.txt")
+        if err := writeMutationFile(path, specs); err != nil {
+                t.Fatal(err)
+        }
+        loaded, err := loadMutationFile(path)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if !reflect.DeepEqual(loaded, specs) {
+                t.Errorf("mutations changed in a write and load round trip")
+        }
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+        "flag"
+        "fmt"
+        "os"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+        "testing"
+)
+
+// Regenerate with: go test *.go -run Golden -update
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// fixedRandom makes every __RANDOM__ render as 123456.
+func fixedRandom(t *testing.T) {
+        saved := randFloat
+        randFloat = func() float64 { return 0.123456 }
+        t.Cleanup(func() { randFloat = saved })
+}
+
+// checkGolden compares got with testdata/name, or rewrites it with -update.
+func checkGolden(t *testing.T, name, got string) {
+        path := filepath.Join("testdata", name)
+        if *updateGolden {
+                if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+                        t.Fatal(err)
+                }
+                return
+        }
+        want, err := os.ReadFile(path)
+        if err != nil {
+                t.Fatalf("%v (run with -update to create it)", err)
+        }
+        if got == string(want) {
+                return
+        }
+        gotLines, wantLines := strings.Split(got, "\n"), strings.Split(string(want), "\n")
+        for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+                var g, w string
+                if i < len(gotLines) {
+                        g = gotLines[i]
+                }
+                if i < len(wantLines) {
+                        w = wantLines[i]
+                }
+                if g != w {
+                        t.Fatalf("%s differs at line %d:\n got: %s\nwant: %s", path, i+1, g, w)
+                }
+        }
+}
+
+// TestMutationGolden locks down the exact bytes of every mutation, one
+// quoted request per line so control characters stay visible in diffs.
+func TestMutationGolden(t *testing.T) {
+        fixedRandom(t)
+        mutations := initMutations()
+        names := make([]string, 0, len(mutations))
+        for name := range mutations {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+
+        var b strings.Builder
+        for _, name := range names {
+                p := mutations[name]
+                p.Method = "POST"
+                p.Host = "example.com"
+                b.WriteString(name + " " + strconv.Quote(p.String()) + "\n")
+        }
+        checkGolden(t, "mutations.golden", b.String())
+}
+
+// TestPayloadGolden covers the body, Content-Length and cache-buster
+// handling of Payload.String.
+func TestPayloadGolden(t *testing.T) {
+        fixedRandom(t)
+        cases := []struct {
+                name string
+                p    Payload
+        }{
+                {"tecl-6", Payload{Body: EndChunk + "X", CL: 6}},
+                {"tecl-5", Payload{Body: EndChunk + "X", CL: 5}},
+                {"clte-4", Payload{Body: Chunked("Z") + EndChunk, CL: 4}},
+                {"clte-11", Payload{Body: Chunked("Z") + EndChunk, CL: 11}},
+                {"auto-cl", Payload{Body: "a=1" + SplitMarker + "&b=2", CL: -1}},
+                {"query", Payload{Endpoint: "/search?q=x", CB: "cb", CL: -1}},
+                {"no-cb", Payload{Endpoint: "/search?q=x", CL: -1}},
+        }
+        var b strings.Builder
+        for _, c := range cases {
+                p := c.p
+                p.Header = tmplOpts.header("Transfer-Encoding: chunked")
+                p.Method = "POST"
+                p.Host = "example.com"
+                if p.Endpoint == "" {
+                        p.Endpoint = "/"
+                }
+                b.WriteString(c.name + " " + strconv.Quote(p.String()) + "\n")
+        }
+        checkGolden(t, "payloads.golden", b.String())
+}
+
+// TestCLMutationGolden locks down the Content-Length family as sent by the
+// CL.CL check with conflicting lengths.
+func TestCLMutationGolden(t *testing.T) {
+        fixedRandom(t)
+        gadgets := clGadgets()
+        names := make([]string, 0, len(gadgets))
+        for name := range gadgets {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+
+        var b strings.Builder
+        for _, name := range names {
+                p := renderTemplate("")
+                p.CLGadget = gadgets[name]
+                p.Method = "POST"
+                p.Host = "example.com"
+                p.Body = clBody
+                p.CL = len(clBody)
+                p.AltCL = 1
+                b.WriteString(name + " " + strconv.Quote(p.String()) + "\n")
+        }
+        checkGolden(t, "clmutations.golden", b.String())
+}
+
+// TestChunkMutationGolden locks down the TECL and CLTE bodies of every
+// chunk-level mutation, including the derived Content-Length values.
+func TestChunkMutationGolden(t *testing.T) {
+        fixedRandom(t)
+        d := &Desyncr{host: "example.com", port: 80, method: "POST", endpoint: "/"}
+        styles := chunkStyles()
+        names := make([]string, 0, len(styles))
+        for name := range styles {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+
+        var b strings.Builder
+        for _, name := range names {
+                p := renderTemplate("Transfer-Encoding: chunked")
+                p.Chunks = styles[name]
+                for ptype := 0; ptype < 2; ptype++ {
+                        b.WriteString(fmt.Sprintf("%s tecl-%d %s\n", name, ptype, strconv.Quote(d.teclPayload(p, ptype).String())))
+                        b.WriteString(fmt.Sprintf("%s clte-%d %s\n", name, ptype, strconv.Quote(d.cltePayload(p, ptype).String())))
+                }
+        }
+        checkGolden(t, "chunkmutations.golden", b.String())
+}
+
+// TestCanonicalChunkLengths pins the lengths the original checks used.
+func TestCanonicalChunkLengths(t *testing.T) {
+        d := &Desyncr{host: "example.com", port: 80, method: "POST", endpoint: "/"}
+        p := renderTemplate("Transfer-Encoding: chunked")
+        for _, c := range []struct {
+                got, want int
+        }{
+                {d.teclPayload(p, 0).CL, 6}, {d.teclPayload(p, 1).CL, 5},
+                {d.cltePayload(p, 0).CL, 4}, {d.cltePayload(p, 1).CL, 11},
+        } {
+                if c.got != c.want {
+                        t.Errorf("got Content-Length %d, want %d", c.got, c.want)
+                }
+        }
+        if body := d.cltePayload(p, 0).Body; body != Chunked("Z")+EndChunk {
+                t.Errorf("canonical CLTE body changed: %q", body)
+        }
+}
+
+// TestLineMutationGolden locks down the request-line variants, alone and
+// stacked on a TE gadget.
+func TestLineMutationGolden(t *testing.T) {
+        fixedRandom(t)
+        lines := requestLines()
+        names := make([]string, 0, len(lines))
+        for name := range lines {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+
+        var b strings.Builder
+        for _, prefix := range []string{"", "tabprefix1+"} {
+                gadget := "Transfer-Encoding: chunked"
+                if prefix != "" {
+                        gadget = teGadgets()["tabprefix1"]
+                }
+                for _, name := range names {
+                        p := renderTemplate(gadget)
+                        p.RequestLine = lines[name]
+                        p.Method = "POST"
+                        p.Host = "example.com"
+                        b.WriteString(prefix + name + " " + strconv.Quote(p.String()) + "\n")
+                }
+        }
+        checkGolden(t, "linemutations.golden", b.String())
+}
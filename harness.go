@@ -0,0 +1,265 @@
+package main
+
+import (
+        "bufio"
+        "errors"
+        "fmt"
+        "io"
+        "net"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// ------------------------------
+// Keep-alive connection harness
+
+// Exchange is the outcome of one request sent on a shared connection.
+type Exchange struct {
+        Request   *Payload
+        Connected bool   // false if the connection could not be opened
+        Status    int    // 0 if no status line was read
+        Response  string // raw response bytes, headers and body
+        Elapsed   time.Duration
+        Err       error
+}
+
+// errConnClosed is reported for requests that could not be answered because
+// the server closed the connection after an earlier response.
+var errConnClosed = errors.New("connection closed by server")
+
+// sendSequence sends reqs over a single keep-alive connection and reads one
+// response per request. When pipelined is set every request is written before
+// any response is read, otherwise each response is read before the next
+// request goes out. The connection is opened with connect, so node pinning
+// and address overrides apply as they do for the checks.
+func (d *Desyncr) sendSequence(reqs []*Payload, pipelined bool) []Exchange {
+        return d.sendSequenceWithin(reqs, pipelined, d.timeout)
+}
+
+// sendSequenceWithin is sendSequence with timeout used for connecting and
+// for every read and write instead of the scan timeout.
+func (d *Desyncr) sendSequenceWithin(reqs []*Payload, pipelined bool, timeout time.Duration) []Exchange {
+        results := make([]Exchange, len(reqs))
+        for i, p := range reqs {
+                results[i].Request = p
+        }
+        conn, err := d.connect(timeout)
+        if err != nil {
+                for i := range results {
+                        results[i].Err = err
+                }
+                return results
+        }
+        defer conn.Close()
+        for i := range results {
+                results[i].Connected = true
+        }
+        br := bufio.NewReader(conn)
+
+        if pipelined {
+                for i, p := range reqs {
+                        if err := writeSegments(conn, p.Segments(d.segments), d.segments, timeout); err != nil {
+                                for j := i; j < len(results); j++ {
+                                        results[j].Err = err
+                                }
+                                return results
+                        }
+                }
+        }
+
+        closed := false
+        for i, p := range reqs {
+                if closed {
+                        results[i].Err = errConnClosed
+                        continue
+                }
+                if !pipelined {
+                        if err := writeSegments(conn, p.Segments(d.segments), d.segments, timeout); err != nil {
+                                results[i].Err = err
+                                closed = true
+                                continue
+                        }
+                }
+                startTime := clock.Now()
+                conn.SetReadDeadline(time.Now().Add(timeout))
+                raw, status, keepAlive, err := readResponse(br, p.Method)
+                results[i].Elapsed = clock.Now().Sub(startTime)
+                results[i].Response = raw
+                results[i].Status = status
+                results[i].Err = err
+                if err != nil || !keepAlive {
+                        closed = true
+                }
+        }
+        return results
+}
+
+// readResponse reads a single HTTP/1.x response from br using the message
+// framing rules (no body for HEAD, 1xx, 204 and 304, then Transfer-Encoding,
+// then Content-Length, then read-until-close). It returns the raw bytes, the
+// status code and whether the connection may carry another response.
+func readResponse(br *bufio.Reader, method string) (string, int, bool, error) {
+        var raw strings.Builder
+        for {
+                status, headers, keepAlive, err := readResponseHead(br, &raw)
+                if err != nil {
+                        return raw.String(), status, false, err
+                }
+                if status >= 100 && status < 200 && status != 101 {
+                        // Interim response, the final one follows on the wire.
+                        continue
+                }
+                if strings.EqualFold(method, "HEAD") || status == 204 || status == 304 {
+                        return raw.String(), status, keepAlive, nil
+                }
+                if te, ok := headers["transfer-encoding"]; ok && strings.Contains(strings.ToLower(te), "chunked") {
+                        err = readChunkedBody(br, &raw)
+                        return raw.String(), status, keepAlive && err == nil, err
+                }
+                if cl, ok := headers["content-length"]; ok {
+                        n, convErr := strconv.ParseInt(strings.TrimSpace(cl), 10, 64)
+                        if convErr != nil || n < 0 {
+                                return raw.String(), status, false, fmt.Errorf("invalid response Content-Length: %q", cl)
+                        }
+                        _, err = io.CopyN(&raw, br, n)
+                        return raw.String(), status, keepAlive && err == nil, err
+                }
+                _, err = io.Copy(&raw, br)
+                if ne, ok := err.(net.Error); ok && ne.Timeout() && raw.Len() > 0 {
+                        err = nil
+                }
+                return raw.String(), status, false, err
+        }
+}
+
+// readResponseHead reads the status line and header block, appending the raw
+// bytes to raw. Header names are lowercased; repeated headers are joined.
+func readResponseHead(br *bufio.Reader, raw *strings.Builder) (int, map[string]string, bool, error) {
+        line, err := br.ReadString('\n')
+        raw.WriteString(line)
+        if err != nil {
+                return 0, nil, false, err
+        }
+        parts := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 3)
+        if len(parts) < 2 || !strings.HasPrefix(parts[0], "HTTP/") {
+                return 0, nil, false, fmt.Errorf("malformed status line: %q", line)
+        }
+        status, err := strconv.Atoi(parts[1])
+        if err != nil {
+                return 0, nil, false, fmt.Errorf("malformed status line: %q", line)
+        }
+        keepAlive := parts[0] != "HTTP/1.0"
+
+        headers := make(map[string]string)
+        for {
+                line, err = br.ReadString('\n')
+                raw.WriteString(line)
+                if err != nil {
+                        return status, headers, false, err
+                }
+                line = strings.TrimRight(line, "\r\n")
+                if line == "" {
+                        break
+                }
+                idx := strings.Index(line, ":")
+                if idx < 0 {
+                        continue
+                }
+                name := strings.ToLower(strings.TrimSpace(line[:idx]))
+                value := strings.TrimSpace(line[idx+1:])
+                if prev, ok := headers[name]; ok {
+                        value = prev + ", " + value
+                }
+                headers[name] = value
+        }
+        if conn, ok := headers["connection"]; ok {
+                switch strings.ToLower(conn) {
+                case "close":
+                        keepAlive = false
+                case "keep-alive":
+                        keepAlive = true
+                }
+        }
+        return status, headers, keepAlive, nil
+}
+
+// readChunkedBody copies a chunked message body, including trailers, to raw.
+func readChunkedBody(br *bufio.Reader, raw *strings.Builder) error {
+        for {
+                line, err := br.ReadString('\n')
+                raw.WriteString(line)
+                if err != nil {
+                        return err
+                }
+                sizeStr := strings.TrimRight(line, "\r\n")
+                if idx := strings.Index(sizeStr, ";"); idx >= 0 {
+                        sizeStr = sizeStr[:idx]
+                }
+                size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+                if err != nil || size < 0 {
+                        return fmt.Errorf("malformed chunk size: %q", line)
+                }
+                if size == 0 {
+                        for {
+                                line, err = br.ReadString('\n')
+                                raw.WriteString(line)
+                                if err != nil {
+                                        return err
+                                }
+                                if strings.TrimRight(line, "\r\n") == "" {
+                                        return nil
+                                }
+                        }
+                }
+                // Chunk data plus its trailing CRLF.
+                if _, err = io.CopyN(raw, br, size); err != nil {
+                        return err
+                }
+                line, err = br.ReadString('\n')
+                raw.WriteString(line)
+                if err != nil {
+                        return err
+                }
+        }
+}
+
+// printExchanges writes one status line per exchange in the usual style.
+func printExchanges(exchanges []Exchange, logh io.Writer) {
+        for i, ex := range exchanges {
+                status := "ERR"
+                if ex.Status != 0 {
+                        status = strconv.Itoa(ex.Status)
+                }
+                msg := fmt.Sprintf("Request %-3d: %s (%.2fs)", i+1, ColorCyan+status+ColorMagenta, ex.Elapsed.Seconds())
+                if ex.Err != nil {
+                        msg += " - " + ex.Err.Error()
+                }
+                printInfo(msg, logh)
+        }
+}
+
+// plainPayload returns a well-formed GET request for the target with no
+// gadget, built from the same base template as the mutations.
+func (d *Desyncr) plainPayload() *Payload {
+        p := renderTemplate("")
+        p.Host = d.hostHeader()
+        p.Method = "GET"
+        p.Endpoint = d.endpoint
+        return p
+}
+
+// keepAliveProbe sends n plain requests on one connection and reports how
+// many the target answered before closing it.
+func (d *Desyncr) keepAliveProbe(n int, pipelined bool) {
+        reqs := make([]*Payload, n)
+        for i := range reqs {
+                reqs[i] = d.plainPayload()
+        }
+        mode := "sequential"
+        if pipelined {
+                mode = "pipelined"
+        }
+        printInfo(fmt.Sprintf("Keep-alive : %s%d %s requests%s", ColorCyan, n, mode, ColorMagenta), d.logh)
+        printExchanges(d.sendSequence(reqs, pipelined), d.logh)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+        "bufio"
+        "io"
+        "strings"
+        "testing"
+)
+
+// TestSendSequencePinned checks the keep-alive harness dials the pinned
+// node rather than resolving the target host.
+func TestSendSequencePinned(t *testing.T) {
+        presets := simulatorPresets["safe"]
+        s, err := startSimulator(presets[0], presets[1])
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer s.Close()
+        d := simDesyncr(s)
+        d.connectIP = d.host
+        d.host = "smuggo.invalid"
+
+        exchanges := d.sendSequence([]*Payload{d.plainPayload(), d.plainPayload()}, false)
+        for i, ex := range exchanges {
+                if ex.Err != nil || ex.Status != 200 {
+                        t.Errorf("request %d: got status %d, error %v", i+1, ex.Status, ex.Err)
+                }
+        }
+}
+
+func TestReadResponse(t *testing.T) {
+        type result struct {
+                raw       string
+                status    int
+                keepAlive bool
+                err       string
+        }
+        const (
+                ok200     = "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+                chunked   = "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n0\r\nX-Trailer: yes\r\n\r\n"
+                continue1 = "HTTP/1.1 100 Continue\r\n\r\n"
+        )
+        tests := []struct {
+                name   string
+                method string
+                input  string
+                want   []result
+                rest   string
+        }{
+                {"content-length", "GET", ok200 + "NEXT", []result{{ok200, 200, true, ""}}, "NEXT"},
+                {"chunked with trailers", "POST", chunked + "NEXT", []result{{chunked, 200, true, ""}}, "NEXT"},
+                {"head with content-length", "HEAD", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nNEXT",
+                        []result{{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", 200, true, ""}}, "NEXT"},
+                {"100-continue then final", "POST", continue1 + ok200, []result{{continue1 + ok200, 200, true, ""}}, ""},
+                {"204", "GET", "HTTP/1.1 204 No Content\r\n\r\nNEXT",
+                        []result{{"HTTP/1.1 204 No Content\r\n\r\n", 204, true, ""}}, "NEXT"},
+                {"304 with content-length", "GET", "HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\nNEXT",
+                        []result{{"HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n", 304, true, ""}}, "NEXT"},
+                {"connection close", "GET", "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nokNEXT",
+                        []result{{"HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok", 200, false, ""}}, "NEXT"},
+                {"http/1.0", "GET", "HTTP/1.0 200 OK\r\nContent-Length: 2\r\n\r\nok",
+                        []result{{"HTTP/1.0 200 OK\r\nContent-Length: 2\r\n\r\nok", 200, false, ""}}, ""},
+                {"http/1.0 keep-alive", "GET", "HTTP/1.0 200 OK\r\nConnection: Keep-Alive\r\nContent-Length: 2\r\n\r\nok",
+                        []result{{"HTTP/1.0 200 OK\r\nConnection: Keep-Alive\r\nContent-Length: 2\r\n\r\nok", 200, true, ""}}, ""},
+                {"read until close", "GET", "HTTP/1.1 200 OK\r\n\r\nall of it",
+                        []result{{"HTTP/1.1 200 OK\r\n\r\nall of it", 200, false, ""}}, ""},
+                {"invalid content-length", "GET", "HTTP/1.1 200 OK\r\nContent-Length: 5x\r\n\r\nhello",
+                        []result{{"HTTP/1.1 200 OK\r\nContent-Length: 5x\r\n\r\n", 200, false, "invalid response Content-Length"}}, "hello"},
+                {"malformed chunk size", "GET", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+                        []result{{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", 200, false, "malformed chunk size"}}, ""},
+                {"malformed status line", "GET", "SSH-2.0-OpenSSH_9.6\r\n",
+                        []result{{"SSH-2.0-OpenSSH_9.6\r\n", 0, false, "malformed status line"}}, ""},
+                {"pipelined", "GET", ok200 + chunked, []result{{ok200, 200, true, ""}, {chunked, 200, true, ""}}, ""},
+        }
+        for _, tt := range tests {
+                br := bufio.NewReader(strings.NewReader(tt.input))
+                for i, want := range tt.want {
+                        raw, status, keepAlive, err := readResponse(br, tt.method)
+                        if raw != want.raw || status != want.status || keepAlive != want.keepAlive {
+                                t.Errorf("%s #%d: got (%q, %d, %v), want (%q, %d, %v)", tt.name, i, raw, status, keepAlive, want.raw, want.status, want.keepAlive)
+                        }
+                        if (err == nil) != (want.err == "") || (err != nil && !strings.Contains(err.Error(), want.err)) {
+                                t.Errorf("%s #%d: got error %v, want %q", tt.name, i, err, want.err)
+                        }
+                }
+                if rest, _ := io.ReadAll(br); string(rest) != tt.rest {
+                        t.Errorf("%s: left %q unread, want %q", tt.name, rest, tt.rest)
+                }
+        }
+}
+
+func TestReadResponseHead(t *testing.T) {
+        input := "HTTP/1.1 302 Found\r\nLocation: /a\r\nSet-Cookie: a=1\r\nset-cookie: b=2\r\nno colon\r\n\r\nbody"
+        br := bufio.NewReader(strings.NewReader(input))
+        var raw strings.Builder
+        status, headers, keepAlive, err := readResponseHead(br, &raw)
+        if err != nil || status != 302 || !keepAlive {
+                t.Fatalf("got (%d, %v, %v)", status, keepAlive, err)
+        }
+        if headers["location"] != "/a" || headers["set-cookie"] != "a=1, b=2" {
+                t.Errorf("got headers %v", headers)
+        }
+        if raw.String() != strings.TrimSuffix(input, "body") {
+                t.Errorf("got raw %q", raw.String())
+        }
+
+        var truncated strings.Builder
+        if _, _, _, err := readResponseHead(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nServer: x")), &truncated); err != io.EOF {
+                t.Errorf("truncated head: got error %v, want EOF", err)
+        }
+}
+
+func TestReadChunkedBody(t *testing.T) {
+        tests := []struct {
+                input string
+                raw   string
+                err   string
+        }{
+                {"3\r\nabc\r\n0\r\n\r\nNEXT", "3\r\nabc\r\n0\r\n\r\n", ""},
+                {"A\r\n0123456789\r\n0\r\nA: 1\r\nB: 2\r\n\r\n", "A\r\n0123456789\r\n0\r\nA: 1\r\nB: 2\r\n\r\n", ""},
+                {"-1\r\n", "-1\r\n", "malformed chunk size"},
+                {"5\r\nab", "5\r\nab", "EOF"},
+                {"0\r\n", "0\r\n", "EOF"},
+        }
+        for _, tt := range tests {
+                var raw strings.Builder
+                err := readChunkedBody(bufio.NewReader(strings.NewReader(tt.input)), &raw)
+                if raw.String() != tt.raw {
+                        t.Errorf("%q: got raw %q, want %q", tt.input, raw.String(), tt.raw)
+                }
+                if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+                        t.Errorf("%q: got error %v, want %q", tt.input, err, tt.err)
+                }
+        }
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+        "sort"
+)
+
+// ------------------------------
+// Request-line mutations
+
+// requestLines returns the request-line variants keyed by mutation name.
+// Each replaces the payload's request line and may add headers after a
+// CRLF. __METHOD_LOWER__ is the method in lowercase.
+func requestLines() map[string]string {
+        lines := make(map[string]string)
+        lines["http10"] = "__METHOD__ __ENDPOINT__ HTTP/1.0\r\nConnection: keep-alive"
+        lines["http12"] = "__METHOD__ __ENDPOINT__ HTTP/1.2"
+        lines["absolute"] = "__METHOD__ http://__HOST____ENDPOINT__ HTTP/1.1"
+        lines["absoluteTLS"] = "__METHOD__ https://__HOST____ENDPOINT__ HTTP/1.1"
+        lines["lineTab"] = "__METHOD__\t__ENDPOINT__\tHTTP/1.1"
+        lines["lineDoubleSpace"] = "__METHOD__  __ENDPOINT__  HTTP/1.1"
+        lines["lineLeadSpace"] = " __METHOD__ __ENDPOINT__ HTTP/1.1"
+        lines["lineTrailSpace"] = "__METHOD__ __ENDPOINT__ HTTP/1.1 "
+        lines["lineVT"] = "__METHOD__\x0b__ENDPOINT__ HTTP/1.1"
+        lines["lowerMethod"] = "__METHOD_LOWER__ __ENDPOINT__ HTTP/1.1"
+        lines["lowerVersion"] = "__METHOD__ __ENDPOINT__ http/1.1"
+        lines["versionJunk"] = "__METHOD__ __ENDPOINT__ HTTP/1.1x"
+        lines["versionJunkWord"] = "__METHOD__ __ENDPOINT__ HTTP/1.1 foo"
+        lines["versionZeros"] = "__METHOD__ __ENDPOINT__ HTTP/01.01"
+        return lines
+}
+
+// runLine runs every request-line variant with a plain Transfer-Encoding
+// header through both timing checks, in name order.
+func (d *Desyncr) runLine() bool {
+        lines := requestLines()
+        names := make([]string, 0, len(lines))
+        for name := range lines {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        found := false
+        for _, name := range names {
+                p := renderTemplate("Transfer-Encoding: chunked")
+                p.RequestLine = lines[name]
+                p.Host = d.hostHeader()
+                if d.createExecTest(name, p) {
+                        found = true
+                        if d.exitEarly {
+                                break
+                        }
+                }
+        }
+        return found
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+        "fmt"
+        "io"
+        "strconv"
+        "strings"
+)
+
+// ------------------------------
+// Mutation metadata
+
+// mutationInfo describes what a mutation targets. Servers only lists
+// documented cases, it is empty when nothing specific is known.
+type mutationInfo struct {
+        Family      string
+        Description string
+        Tags        []string
+        Servers     []string
+        References  []string
+}
+
+const (
+        refFields      = "https://www.rfc-editor.org/rfc/rfc9112#section-5"
+        refFolding     = "https://www.rfc-editor.org/rfc/rfc9112#section-5.2"
+        refLineEnds    = "https://www.rfc-editor.org/rfc/rfc9112#section-2.2"
+        refRequestLine = "https://www.rfc-editor.org/rfc/rfc9112#section-3"
+        refTE          = "https://www.rfc-editor.org/rfc/rfc9112#section-6.1"
+        refLength      = "https://www.rfc-editor.org/rfc/rfc9112#section-6.3"
+        refChunked     = "https://www.rfc-editor.org/rfc/rfc9112#section-7.1"
+        refDesync      = "https://portswigger.net/research/http-desync-attacks-request-smuggling-reborn"
+        refSmuggler    = "https://github.com/defparam/smuggler"
+)
+
+func cveRef(id string) string {
+        return "https://nvd.nist.gov/vuln/detail/" + id
+}
+
+// mutationTable holds the hand-written metadata. Generated names are
+// described by mutationMeta.
+var mutationTable = map[string]mutationInfo{
+        // Transfer-Encoding gadgets.
+        "nameprefix1":  {"te", "Space before the header name, an obsolete line fold of the previous header", []string{"whitespace", "folding"}, []string{"Netty before 4.1.44 (CVE-2020-7238)"}, []string{refFolding, cveRef("CVE-2020-7238"), refSmuggler}},
+        "tabprefix1":   {"te", "Tab as the whitespace after the colon, valid but rarely seen", []string{"whitespace"}, nil, []string{refFields, refSmuggler}},
+        "tabprefix2":   {"te", "Tabs around the colon, whitespace before the colon is forbidden", []string{"whitespace"}, nil, []string{refFields, refSmuggler}},
+        "spacejoin1":   {"te", "Space instead of the hyphen in the header name", []string{"whitespace", "name"}, nil, []string{refSmuggler}},
+        "underjoin1":   {"te", "Underscore instead of the hyphen, matched by CGI-style normalisation", []string{"name", "normalisation"}, nil, []string{refSmuggler}},
+        "smashed":      {"te", "Space in the name and no whitespace after the colon", []string{"whitespace", "name"}, nil, []string{refSmuggler}},
+        "space1":       {"te", "Space before the colon, which must be rejected", []string{"whitespace"}, []string{"Go net/http before 1.12.10 and 1.13.1 (CVE-2019-16276)"}, []string{refFields, cveRef("CVE-2019-16276"), refSmuggler}},
+        "valueprefix1": {"te", "Two spaces before the value", []string{"whitespace"}, nil, []string{refSmuggler}},
+        "vertprefix1":  {"te", "Vertical tab before the value", []string{"whitespace", "control-char"}, []string{"HAProxy before 2.0.6 in legacy mode (CVE-2019-18277)"}, []string{refDesync, cveRef("CVE-2019-18277"), refSmuggler}},
+        "commaCow":     {"te", "chunked followed by an unknown coding, so chunked is not final", []string{"list"}, nil, []string{refLength, refSmuggler}},
+        "cowComma":     {"te", "Unknown coding before chunked", []string{"list"}, nil, []string{refTE, refSmuggler}},
+        "contentEnc":   {"te", "chunked sent as Content-Encoding, for parsers that match any *-Encoding", []string{"name", "decoy"}, nil, []string{refSmuggler}},
+        "linewrapped1": {"te", "Value folded onto the next line after a bare LF", []string{"folding", "line-ending"}, nil, []string{refFolding, refSmuggler}},
+        "quoted":       {"te", "Value in double quotes", []string{"quoting"}, nil, []string{refSmuggler}},
+        "aposed":       {"te", "Value in single quotes", []string{"quoting"}, nil, []string{refSmuggler}},
+        "lazygrep":     {"te", "Truncated value \"chunk\" for prefix matching parsers", []string{"value"}, nil, []string{refSmuggler}},
+        "sarcasm":      {"te", "Mixed case name and value, valid but often mishandled", []string{"case"}, nil, []string{refSmuggler}},
+        "yelling":      {"te", "Uppercase name and value", []string{"case"}, nil, []string{refSmuggler}},
+        "0dsuffix":     {"te", "CR after the value, before the CRLF", []string{"control-char", "line-ending"}, nil, []string{refLineEnds, refSmuggler}},
+        "tabsuffix":    {"te", "Tab after the value", []string{"whitespace"}, nil, []string{refSmuggler}},
+        "revdualchunk": {"te", "Unknown coding header followed by a chunked header", []string{"duplicate"}, nil, []string{refTE, refDesync, refSmuggler}},
+        "0dspam":       {"te", "CR inside the header name", []string{"control-char", "name"}, nil, []string{refSmuggler}},
+        "nested":       {"te", "chunked between unknown tokens without commas", []string{"list"}, nil, []string{refSmuggler}},
+        "spaceFF":      {"te", "Byte 0xff as the whitespace after the colon", []string{"whitespace", "non-ascii"}, nil, []string{refSmuggler}},
+        "accentCH":     {"te", "Byte 0x96 inside the value", []string{"non-ascii"}, nil, []string{refSmuggler}},
+        "accentTE":     {"te", "Byte 0x82 inside the name", []string{"non-ascii", "name"}, nil, []string{refSmuggler}},
+        "x-rout":       {"te", "Preceding header ends in a bare CR", []string{"line-ending", "control-char"}, nil, []string{refLineEnds, refSmuggler}},
+        "x-nout":       {"te", "Preceding header ends in a bare LF", []string{"line-ending"}, []string{"Node.js llhttp, 2022 releases (CVE-2022-32214)"}, []string{refLineEnds, cveRef("CVE-2022-32214"), refSmuggler}},
+
+        // Content-Length gadgets.
+        "clDup":      {"cl", "Two Content-Length headers, the conflicting value first", []string{"duplicate"}, []string{"Netty before 4.1.44 (CVE-2019-20445)"}, []string{refLength, cveRef("CVE-2019-20445")}},
+        "clDupRev":   {"cl", "Two Content-Length headers, the conflicting value last", []string{"duplicate"}, []string{"Netty before 4.1.44 (CVE-2019-20445)"}, []string{refLength, cveRef("CVE-2019-20445")}},
+        "clComma":    {"cl", "Comma list with the conflicting value first", []string{"list", "duplicate"}, nil, []string{refLength}},
+        "clCommaRev": {"cl", "Comma list with the conflicting value last", []string{"list", "duplicate"}, nil, []string{refLength}},
+
+        // Chunk-level mutations.
+        "chunkZeros":      {"chunk", "Chunk sizes padded with leading zeros", []string{"chunk", "size"}, nil, []string{refChunked}},
+        "chunkUpper":      {"chunk", "Uppercase hex chunk size", []string{"chunk", "size", "case"}, nil, []string{refChunked}},
+        "chunkLower":      {"chunk", "Lowercase hex chunk size, the baseline for chunkUpper", []string{"chunk", "size", "case"}, nil, []string{refChunked}},
+        "chunk0x":         {"chunk", "Chunk sizes with a 0x prefix", []string{"chunk", "size"}, nil, []string{refChunked}},
+        "chunkOverflow":   {"chunk", "Chunk sizes that wrap to the real size in 64 bits", []string{"chunk", "size", "overflow"}, nil, []string{refChunked}},
+        "chunkLongSize":   {"chunk", "Chunk sizes with 40 hex digits", []string{"chunk", "size"}, nil, []string{refChunked}},
+        "chunkSizeSpace":  {"chunk", "Space after the chunk size", []string{"chunk", "whitespace"}, nil, []string{refChunked}},
+        "chunkSizeTab":    {"chunk", "Tab after the chunk size", []string{"chunk", "whitespace"}, nil, []string{refChunked}},
+        "chunkExt":        {"chunk", "Chunk extension with a value", []string{"chunk", "extension"}, nil, []string{refChunked}},
+        "chunkExtBare":    {"chunk", "Empty chunk extension", []string{"chunk", "extension"}, nil, []string{refChunked}},
+        "chunkExtSpace":   {"chunk", "Whitespace around the extension separator", []string{"chunk", "extension", "whitespace"}, nil, []string{refChunked}},
+        "chunkExtQuoted":  {"chunk", "Quoted extension value with an escaped quote", []string{"chunk", "extension", "quoting"}, nil, []string{refChunked}},
+        "chunkExtLong":    {"chunk", "4KB chunk extension", []string{"chunk", "extension"}, nil, []string{refChunked}},
+        "chunkBareLF":     {"chunk", "Bare LF line endings throughout the chunked body", []string{"chunk", "line-ending"}, nil, []string{refChunked, refLineEnds}},
+        "chunkSizeLF":     {"chunk", "Bare LF after chunk sizes", []string{"chunk", "line-ending"}, nil, []string{refChunked, refLineEnds}},
+        "chunkDataLF":     {"chunk", "Bare LF after chunk data", []string{"chunk", "line-ending"}, nil, []string{refChunked, refLineEnds}},
+        "chunkSizeCR":     {"chunk", "Bare CR after chunk sizes", []string{"chunk", "line-ending", "control-char"}, nil, []string{refChunked, refLineEnds}},
+        "chunkTrailer":    {"chunk", "Trailer field after the last chunk", []string{"chunk", "trailer"}, nil, []string{refChunked}},
+        "chunkTrailerTE":  {"chunk", "Transfer-Encoding sent as a trailer field", []string{"chunk", "trailer"}, nil, []string{refChunked}},
+        "chunkTrailerBad": {"chunk", "Trailer line without a colon", []string{"chunk", "trailer"}, nil, []string{refChunked}},
+        "chunkOversize":   {"chunk", "Chunk size 16 bytes larger than the data sent", []string{"chunk", "size"}, nil, []string{refChunked}},
+
+        // Request-line mutations.
+        "http10":          {"line", "HTTP/1.0 with Connection: keep-alive, where Transfer-Encoding is suspect", []string{"version"}, nil, []string{refTE}},
+        "http12":          {"line", "Unknown minor version HTTP/1.2", []string{"version"}, nil, []string{refRequestLine}},
+        "absolute":        {"line", "Absolute-form http:// request target", []string{"target"}, nil, []string{refRequestLine}},
+        "absoluteTLS":     {"line", "Absolute-form https:// request target", []string{"target"}, nil, []string{refRequestLine}},
+        "lineTab":         {"line", "Tabs as request-line separators", []string{"whitespace"}, nil, []string{refRequestLine}},
+        "lineDoubleSpace": {"line", "Doubled spaces in the request line", []string{"whitespace"}, nil, []string{refRequestLine}},
+        "lineLeadSpace":   {"line", "Space before the method", []string{"whitespace"}, nil, []string{refRequestLine}},
+        "lineTrailSpace":  {"line", "Space after the version", []string{"whitespace"}, nil, []string{refRequestLine}},
+        "lineVT":          {"line", "Vertical tab between method and target", []string{"whitespace", "control-char"}, nil, []string{refRequestLine}},
+        "lowerMethod":     {"line", "Lowercase method", []string{"case"}, nil, []string{refRequestLine}},
+        "lowerVersion":    {"line", "Lowercase http/1.1 version", []string{"case", "version"}, nil, []string{refRequestLine}},
+        "versionJunk":     {"line", "Junk appended to the version", []string{"version"}, nil, []string{refRequestLine}},
+        "versionJunkWord": {"line", "Extra word after the version", []string{"version", "whitespace"}, nil, []string{refRequestLine}},
+        "versionZeros":    {"line", "Version digits with leading zeros", []string{"version"}, nil, []string{refRequestLine}},
+}
+
+// clObfuscations describes the obfuscated Content-Length labels of
+// clGadgets.
+var clObfuscations = map[string]string{
+        "Plus":      "a plus sign",
+        "Zeros":     "leading zeros",
+        "SpaceName": "a space before the colon",
+        "Tab":       "a tab after the colon",
+        "TrailJunk": "junk after the value",
+        "Lower":     "a lowercase name",
+        "Nbsp":      "byte 0xa0 after the colon",
+}
+
+// controlPositions names the slots of the "%02x-XX-XX-XX" gadget names.
+var controlPositions = []string{"before the name", "before the colon", "after the colon", "after the value"}
+
+// byteTags classifies a byte used as a gadget character.
+func byteTags(b int) []string {
+        switch {
+        case b >= 0x80:
+                return []string{"whitespace", "non-ascii"}
+        case b != '\t' && (b < 0x20 || b == 0x7f):
+                return []string{"whitespace", "control-char"}
+        }
+        return []string{"whitespace"}
+}
+
+// mutationMeta returns the metadata for a mutation of any family, including
+// generated, fuzzed and stacked names.
+func mutationMeta(name string) mutationInfo {
+        if _, ok := customGadgets[name]; ok {
+                // A --mutations entry may reuse a built-in name for another gadget.
+                return customMeta()
+        }
+        if info, ok := mutationTable[name]; ok {
+                return info
+        }
+        if strings.Contains(name, "+") {
+                var merged mutationInfo
+                var descs []string
+                for _, part := range strings.Split(name, "+") {
+                        info := mutationMeta(part)
+                        descs = append(descs, info.Description)
+                        merged.Tags = mergeStrings(merged.Tags, info.Tags)
+                        merged.Servers = mergeStrings(merged.Servers, info.Servers)
+                        merged.References = mergeStrings(merged.References, info.References)
+                }
+                merged.Family = "combo"
+                merged.Description = strings.Join(descs, "; ")
+                return merged
+        }
+        if strings.HasPrefix(name, "fuzz-") {
+                return mutationInfo{Family: "fuzz", Description: "Generated from " + strings.Join(strings.Split(name, "-")[1:], ", "), Tags: []string{"generated"}, References: []string{refFields}}
+        }
+        for _, prefix := range []struct{ name, where string }{
+                {"midspace-", "as the whitespace after the colon"},
+                {"postspace-", "after the name"},
+                {"prespace-", "before the name"},
+                {"endspace-", "after the value"},
+        } {
+                if hex := strings.TrimPrefix(name, prefix.name); hex != name {
+                        if b, err := strconv.ParseUint(hex, 16, 8); err == nil {
+                                return mutationInfo{Family: "te", Description: fmt.Sprintf("Byte 0x%s %s", hex, prefix.where), Tags: byteTags(int(b)), References: []string{refFields, refSmuggler}}
+                        }
+                }
+        }
+        if slots := strings.Split(name, "-"); len(slots) == 4 {
+                var where []string
+                b := -1
+                for i, slot := range slots {
+                        if slot == "XX" {
+                                continue
+                        }
+                        n, err := strconv.ParseUint(slot, 16, 8)
+                        if err != nil {
+                                b = -1
+                                break
+                        }
+                        b = int(n)
+                        where = append(where, controlPositions[i])
+                }
+                if b >= 0 && len(where) == 2 {
+                        return mutationInfo{Family: "te", Description: fmt.Sprintf("Byte 0x%02x %s and %s", b, where[0], where[1]), Tags: byteTags(b), References: []string{refFields, refSmuggler}}
+                }
+        }
+        if hex := strings.TrimPrefix(name, "cl-"); hex != name {
+                if b, err := strconv.ParseUint(hex, 16, 8); err == nil {
+                        return mutationInfo{Family: "cl", Description: fmt.Sprintf("Conflicting Content-Length with byte 0x%s after the colon", hex), Tags: append([]string{"duplicate"}, byteTags(int(b))...), References: []string{refLength}}
+                }
+        }
+        if label := strings.TrimPrefix(name, "cl"); label != name {
+                order := "after"
+                if trimmed := strings.TrimSuffix(label, "Rev"); trimmed != label {
+                        label, order = trimmed, "before"
+                }
+                if what, ok := clObfuscations[label]; ok {
+                        return mutationInfo{Family: "cl", Description: fmt.Sprintf("Conflicting Content-Length with %s, %s the real one", what, order), Tags: []string{"duplicate", "obfuscated"}, References: []string{refLength}}
+                }
+        }
+        return customMeta()
+}
+
+// customMeta describes a gadget loaded from --mutations.
+func customMeta() mutationInfo {
+        return mutationInfo{Family: "custom", Description: "No metadata, loaded from --mutations", Tags: []string{"custom"}}
+}
+
+// mergeStrings appends the entries of add missing from list.
+func mergeStrings(list, add []string) []string {
+        for _, s := range add {
+                if !containsString(list, s) {
+                        list = append(list, s)
+                }
+        }
+        return list
+}
+
+// allMutationNames lists every mutation of the scan families in family and
+// name order.
+func allMutationNames() []string {
+        var names []string
+        for _, family := range comboFamilies {
+                for _, opt := range familyOptions(family) {
+                        names = append(names, opt.Name)
+                }
+        }
+        return names
+}
+
+// listMutations writes one entry per mutation whose name, family or tags
+// match filter, or all of them when filter is empty.
+func listMutations(w io.Writer, filter string) int {
+        count := 0
+        for _, name := range allMutationNames() {
+                info := mutationMeta(name)
+                if filter != "" && filter != name && filter != info.Family && !containsString(info.Tags, filter) {
+                        continue
+                }
+                count++
+                fmt.Fprintf(w, "%-20s %-6s %s\n", name, info.Family, info.Description)
+                fmt.Fprintf(w, "%-20s tags: %s\n", "", strings.Join(info.Tags, ", "))
+                if len(info.Servers) > 0 {
+                        fmt.Fprintf(w, "%-20s affects: %s\n", "", strings.Join(info.Servers, "; "))
+                }
+                for _, ref := range info.References {
+                        fmt.Fprintf(w, "%-20s ref: %s\n", "", ref)
+                }
+        }
+        return count
+}
+
+func containsString(list []string, s string) bool {
+        for _, have := range list {
+                if have == s {
+                        return true
+                }
+        }
+        return false
+}
+
+// reportMutation prints the metadata of a mutation below its finding.
+func (d *Desyncr) reportMutation(name string) {
+        info := mutationMeta(name)
+        printInfo("Mutation   : "+ColorCyan+info.Description+ColorMagenta, d.logh)
+        printInfo("Tags       : "+ColorCyan+strings.Join(info.Tags, ", ")+ColorMagenta, d.logh)
+        if len(info.Servers) > 0 {
+                printInfo("Affects    : "+ColorCyan+strings.Join(info.Servers, "; ")+ColorMagenta, d.logh)
+        }
+        for _, ref := range info.References {
+                printInfo("Reference  : "+ColorCyan+ref+ColorMagenta, d.logh)
+        }
+}
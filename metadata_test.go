@@ -0,0 +1,88 @@
+package main
+
+import (
+        "io"
+        "path/filepath"
+        "strings"
+        "testing"
+)
+
+// TestMutationMetadata checks every built-in mutation is described and
+// tagged, so a new gadget cannot be added without metadata.
+func TestMutationMetadata(t *testing.T) {
+        for _, name := range allMutationNames() {
+                info := mutationMeta(name)
+                if info.Family == "custom" || info.Description == "" || len(info.Tags) == 0 {
+                        t.Errorf("%s: missing metadata %+v", name, info)
+                }
+        }
+}
+
+func TestMutationMetaGenerated(t *testing.T) {
+        tests := []struct {
+                name, family, desc, tag string
+        }{
+                {"midspace-0b", "te", "Byte 0x0b as the whitespace after the colon", "control-char"},
+                {"0a-XX-XX-0a", "te", "Byte 0x0a before the name and after the value", "control-char"},
+                {"cl-09", "cl", "Conflicting Content-Length with byte 0x09 after the colon", "duplicate"},
+                {"clNbspRev", "cl", "Conflicting Content-Length with byte 0xa0 after the colon, before the real one", "obfuscated"},
+                {"space1+clDup", "combo", "Space before the colon, which must be rejected; Two Content-Length headers, the conflicting value first", "duplicate"},
+                {"fuzz-canon-spcol-chunked-single", "fuzz", "Generated from canon, spcol, chunked, single", "generated"},
+        }
+        for _, tt := range tests {
+                info := mutationMeta(tt.name)
+                if info.Family != tt.family || info.Description != tt.desc || !containsString(info.Tags, tt.tag) {
+                        t.Errorf("%s: got %+v", tt.name, info)
+                }
+        }
+}
+
+func TestListMutations(t *testing.T) {
+        var b strings.Builder
+        if n := listMutations(&b, "duplicate"); n == 0 {
+                t.Fatal("no mutation tagged duplicate")
+        }
+        if !strings.Contains(b.String(), "affects: Netty before 4.1.44 (CVE-2019-20445)") {
+                t.Errorf("clDup servers missing from listing:\n%s", b.String())
+        }
+        if strings.Contains(b.String(), "chunkZeros") {
+                t.Errorf("tag filter listed an untagged mutation")
+        }
+
+        b.Reset()
+        listMutations(&b, "control-char")
+        if !strings.Contains(b.String(), "affects: HAProxy before 2.0.6 in legacy mode (CVE-2019-18277)") {
+                t.Errorf("vertprefix1 servers missing from listing:\n%s", b.String())
+        }
+}
+
+// TestListMutationsFile checks a --mutations file replaces the built-in TE
+// gadgets in the listing as it does in a scan.
+func TestListMutationsFile(t *testing.T) {
+        saved := customGadgets
+        t.Cleanup(func() { customGadgets = saved })
+        path := filepath.Join(t.TempDir(), "mutations.txt")
+        if err := writeMutationFile(path, []mutationSpec{{Name: "myGadget", Gadget: "Transfer-Encoding:\x00chunked"}}); err != nil {
+                t.Fatal(err)
+        }
+        if n, err := useMutationFile(path); err != nil || n != 1 {
+                t.Fatalf("loaded %d mutations, error %v", n, err)
+        }
+        var b strings.Builder
+        listMutations(&b, "")
+        if !strings.Contains(b.String(), "myGadget") || strings.Contains(b.String(), "tabprefix1") {
+                t.Errorf("built-in TE gadgets listed instead of the file's:\n%s", b.String())
+        }
+        if n := listMutations(io.Discard, "custom"); n != 1 {
+                t.Errorf("listed %d custom mutations, want 1", n)
+        }
+
+        // A file entry reusing a built-in name is not described as the built-in.
+        customGadgets = map[string]string{"space1": "Transfer-Encoding:\x00chunked"}
+        if info := mutationMeta("space1"); info.Family != "custom" {
+                t.Errorf("space1 from --mutations got built-in metadata %+v", info)
+        }
+        if info := mutationMeta("space1+clDup"); containsString(info.Tags, "whitespace") || !containsString(info.Tags, "custom") {
+                t.Errorf("space1+clDup from --mutations got built-in metadata %+v", info)
+        }
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+        "bufio"
+        "fmt"
+        "os"
+        "strconv"
+        "strings"
+)
+
+// ------------------------------
+// Mutation config files
+
+// mutationSpec is one entry of a mutation config file.
+type mutationSpec struct {
+        Name   string
+        Gadget string
+}
+
+// customGadgets replaces the built-in TE gadgets when --mutations is given.
+var customGadgets map[string]string
+
+// mutationGadgets returns the gadgets a scan runs: those from --mutations,
+// or the built-in set.
+func mutationGadgets() map[string]string {
+        if customGadgets != nil {
+                return customGadgets
+        }
+        return teGadgets()
+}
+
+// loadMutationFile reads a mutation config file. Each line is a name and a
+// Go-quoted gadget, so control bytes and invalid UTF-8 survive editing:
+//
+//	space1 "Transfer-Encoding : chunked"
+//
+// Blank lines and # comments are skipped.
+func loadMutationFile(path string) ([]mutationSpec, error) {
+        f, err := os.Open(path)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+        var specs []mutationSpec
+        scanner := bufio.NewScanner(f)
+        for lineNo := 1; scanner.Scan(); lineNo++ {
+                line := strings.TrimSpace(scanner.Text())
+                if line == "" || strings.HasPrefix(line, "#") {
+                        continue
+                }
+                idx := strings.IndexAny(line, " \t")
+                if idx < 0 {
+                        return nil, fmt.Errorf("%s:%d: want 'name \"gadget\"'", path, lineNo)
+                }
+                gadget, err := strconv.Unquote(strings.TrimSpace(line[idx:]))
+                if err != nil {
+                        return nil, fmt.Errorf("%s:%d: invalid gadget: %v", path, lineNo, err)
+                }
+                specs = append(specs, mutationSpec{Name: line[:idx], Gadget: gadget})
+        }
+        return specs, scanner.Err()
+}
+
+// useMutationFile loads path and makes its gadgets replace the built-in TE
+// gadgets. It returns how many were loaded.
+func useMutationFile(path string) (int, error) {
+        specs, err := loadMutationFile(path)
+        if err != nil {
+                return 0, err
+        }
+        customGadgets = make(map[string]string)
+        for _, spec := range specs {
+                customGadgets[spec.Name] = spec.Gadget
+        }
+        return len(specs), nil
+}
+
+// dedupeSpecs drops specs whose name was already seen, keeping the first.
+func dedupeSpecs(specs []mutationSpec) []mutationSpec {
+        seen := make(map[string]bool)
+        var out []mutationSpec
+        for _, spec := range specs {
+                if !seen[spec.Name] {
+                        seen[spec.Name] = true
+                        out = append(out, spec)
+                }
+        }
+        return out
+}
+
+// writeMutationFile writes specs in the format loadMutationFile reads.
+func writeMutationFile(path string, specs []mutationSpec) error {
+        var b strings.Builder
+        for _, spec := range specs {
+                b.WriteString(spec.Name + " " + strconv.Quote(spec.Gadget) + "\n")
+        }
+        return os.WriteFile(path, []byte(b.String()), 0644)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+        "fmt"
+        "net/http"
+        "net/url"
+        "strings"
+)
+
+// ------------------------------
+// Redirect preflight
+
+// targetURL rebuilds the URL the Desyncr is currently pointed at.
+func (d *Desyncr) targetURL() *url.URL {
+        scheme := "http"
+        if d.sslFlag {
+                scheme = "https"
+        }
+        u := &url.URL{Scheme: scheme, Host: formatHostHeader(d.host, d.port, d.sslFlag), Path: "/"}
+        if ref, err := url.Parse(d.endpoint); err == nil && d.endpoint != "" {
+                u.Path, u.RawPath, u.RawQuery = ref.Path, ref.RawPath, ref.RawQuery
+        }
+        return u
+}
+
+// retargetTo points the Desyncr at u. The vhost and any node pin only apply
+// to the original host name and are dropped if the redirect leaves it; a
+// scheme or port change on the same host, such as http to https, keeps them.
+func (d *Desyncr) retargetTo(u *url.URL) bool {
+        host, port, endpoint, sslFlag, ok := splitURL(u)
+        if !ok {
+                return false
+        }
+        if !strings.EqualFold(host, d.host) {
+                d.vhost = ""
+                d.connectIP = ""
+        }
+        if d.connectIP == "" && (!strings.EqualFold(host, d.host) || port != d.port) {
+                d.ip = targetIP(host, port)
+        }
+        d.host, d.port, d.endpoint, d.sslFlag = host, port, endpoint, sslFlag
+        d.url = u.String()
+        return true
+}
+
+// preflight follows up to maxHops redirects from the target with plain GET
+// requests and reports each hop. With retarget set the scan is moved to the
+// final URL, otherwise the Desyncr is left untouched. It returns the final
+// URL reached.
+func (d *Desyncr) preflight(maxHops int, retarget bool) *url.URL {
+        hop := *d
+        visited := map[string]bool{hop.targetURL().String(): true}
+        for i := 1; i <= maxHops; i++ {
+                ex := hop.sendSequence([]*Payload{hop.plainPayload()}, false)[0]
+                if ex.Status == 0 {
+                        printInfo(fmt.Sprintf("Preflight  : %s (%v)", ColorCyan+"no response"+ColorMagenta, ex.Err), d.logh)
+                        break
+                }
+                location := responseHeader(ex.Response, "Location")
+                if !isRedirect(ex.Status) || location == "" {
+                        printInfo(fmt.Sprintf("Preflight  : %s%d%s at %s", ColorCyan, ex.Status, ColorMagenta, hop.targetURL()), d.logh)
+                        break
+                }
+                next, err := hop.targetURL().Parse(location)
+                if err != nil {
+                        printInfo(fmt.Sprintf("Redirect %-2d: %d -> %s (unparseable)", i, ex.Status, location), d.logh)
+                        break
+                }
+                printInfo(fmt.Sprintf("Redirect %-2d: %d -> %s", i, ex.Status, ColorCyan+next.String()+ColorMagenta), d.logh)
+                if visited[next.String()] {
+                        printInfo("Preflight  : "+ColorYellow+"redirect loop"+ColorMagenta, d.logh)
+                        break
+                }
+                visited[next.String()] = true
+                if !hop.retargetTo(next) {
+                        printInfo("Preflight  : "+ColorYellow+"redirect leaves http(s), stopping"+ColorMagenta, d.logh)
+                        break
+                }
+        }
+        final := hop.targetURL()
+        if retarget && final.String() != d.targetURL().String() {
+                d.retargetTo(final)
+                printInfo("Retarget   : "+ColorCyan+final.String(), d.logh)
+        }
+        return final
+}
+
+// responseHeader returns the first value of header name in a raw response.
+func responseHeader(raw, name string) string {
+        head := raw
+        if idx := strings.Index(raw, "\r\n\r\n"); idx >= 0 {
+                head = raw[:idx]
+        }
+        lines := strings.Split(head, "\r\n")
+        for _, line := range lines[1:] {
+                if headerName(line) == strings.ToLower(name) {
+                        return strings.TrimSpace(line[strings.Index(line, ":")+1:])
+                }
+        }
+        return ""
+}
+
+// isRedirect reports whether status is one the preflight follows.
+func isRedirect(status int) bool {
+        switch status {
+        case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+                http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+                return true
+        }
+        return false
+}
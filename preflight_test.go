@@ -0,0 +1,103 @@
+package main
+
+import (
+        "net"
+        "net/http"
+        "net/http/httptest"
+        "strconv"
+        "strings"
+        "testing"
+        "time"
+)
+
+// redirectServer answers each path in routes with a 302 to its value, in
+// which __PORT__ stands for the server's port, and any other path with 200.
+func redirectServer(t *testing.T, routes map[string]string) (*httptest.Server, string, int) {
+        var srv *httptest.Server
+        srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if to, ok := routes[r.URL.Path]; ok {
+                        _, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+                        http.Redirect(w, r, strings.ReplaceAll(to, "__PORT__", port), http.StatusFound)
+                        return
+                }
+                w.Write([]byte("ok"))
+        }))
+        t.Cleanup(srv.Close)
+        host, portStr, _ := net.SplitHostPort(srv.Listener.Addr().String())
+        port, _ := strconv.Atoi(portStr)
+        return srv, host, port
+}
+
+func TestPreflight(t *testing.T) {
+        srv, host, port := redirectServer(t, map[string]string{
+                "/a":     "/b",
+                "/b":     "/c?cb=111",
+                "/loop1": "/loop2",
+                "/loop2": "/loop1",
+                "/ftp":   "ftp://files.test/",
+        })
+        tests := []struct {
+                name     string
+                start    string
+                maxHops  int
+                wantPath string
+        }{
+                {"chain", "/a", 5, "/c?cb=111"},
+                {"hop limit", "/a", 1, "/b"},
+                {"no redirect", "/c", 5, "/c"},
+                {"loop", "/loop1", 5, "/loop2"},
+                {"leaves http", "/ftp", 5, "/ftp"},
+        }
+        for _, tt := range tests {
+                for _, retarget := range []bool{false, true} {
+                        d := &Desyncr{host: host, port: port, ip: host, endpoint: tt.start, timeout: time.Second, segments: defaultSegmentPlan()}
+                        final := d.preflight(tt.maxHops, retarget)
+                        if want := srv.URL + tt.wantPath; final.String() != want {
+                                t.Errorf("%s: reached %s, want %s", tt.name, final, want)
+                        }
+                        wantEndpoint := tt.start
+                        if retarget {
+                                wantEndpoint = tt.wantPath
+                        }
+                        if d.endpoint != wantEndpoint {
+                                t.Errorf("%s (retarget %v): endpoint %q, want %q", tt.name, retarget, d.endpoint, wantEndpoint)
+                        }
+                }
+        }
+}
+
+// TestRetargetHostChange checks the vhost and node pin survive a redirect on
+// the same host, including a move to https on another port, but are dropped
+// when it moves to another host. app.test does not resolve, so every hop on
+// it must go through the pin.
+func TestRetargetHostChange(t *testing.T) {
+        useResolver(t, stubResolver{"other.test": {net.ParseIP("127.0.0.1")}})
+        tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.Write([]byte("ok"))
+        }))
+        t.Cleanup(tlsSrv.Close)
+        _, tlsPort, _ := net.SplitHostPort(tlsSrv.Listener.Addr().String())
+        _, host, port := redirectServer(t, map[string]string{
+                "/same":   "/landing",
+                "/secure": "https://app.test:" + tlsPort + "/landing",
+                "/away":   "http://other.test:__PORT__/landing",
+        })
+
+        d := &Desyncr{host: "app.test", port: port, connectIP: host, vhost: "vhost.test", endpoint: "/same", timeout: time.Second, segments: defaultSegmentPlan()}
+        d.preflight(5, true)
+        if d.host != "app.test" || d.vhost != "vhost.test" || d.connectIP != host || d.endpoint != "/landing" {
+                t.Errorf("same host: got host %q vhost %q connectIP %q endpoint %q", d.host, d.vhost, d.connectIP, d.endpoint)
+        }
+
+        d.endpoint = "/secure"
+        final := d.preflight(5, true)
+        if final.String() != "https://app.test:"+tlsPort+"/landing" || !d.sslFlag || d.vhost != "vhost.test" || d.connectIP != host {
+                t.Errorf("http to https: reached %s, got ssl %v vhost %q connectIP %q", final, d.sslFlag, d.vhost, d.connectIP)
+        }
+
+        d.host, d.port, d.sslFlag, d.endpoint = "app.test", port, false, "/away"
+        d.preflight(5, true)
+        if d.host != "other.test" || d.vhost != "" || d.connectIP != "" || d.ip != "127.0.0.1" || d.endpoint != "/landing" {
+                t.Errorf("new host: got host %q vhost %q connectIP %q ip %q endpoint %q", d.host, d.vhost, d.connectIP, d.ip, d.endpoint)
+        }
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+        "bufio"
+        "bytes"
+        "crypto/tls"
+        "encoding/base64"
+        "encoding/binary"
+        "io"
+        "net"
+        "net/http"
+        "strconv"
+        "strings"
+        "testing"
+        "time"
+)
+
+// fakeHTTPProxy answers CONNECT requests with status, or with 407 when auth
+// is set and the request does not carry it as Proxy-Authorization. On success
+// it tunnels to the requested address; with rewrite set it terminates TLS
+// tunnels itself and re-encrypts towards the target, passing decrypted client
+// bytes through rewrite.
+type fakeHTTPProxy struct {
+        status  string
+        auth    string
+        rewrite func([]byte) []byte
+}
+
+// startFakeHTTPProxy serves p until the test ends and returns its address.
+func startFakeHTTPProxy(t *testing.T, p *fakeHTTPProxy) string {
+        ln, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { ln.Close() })
+        go func() {
+                for {
+                        conn, err := ln.Accept()
+                        if err != nil {
+                                return
+                        }
+                        go p.handle(conn)
+                }
+        }()
+        return ln.Addr().String()
+}
+
+// peekByte returns the next byte from r without consuming it, or 0.
+func peekByte(r *bufio.Reader) byte {
+        b, err := r.Peek(1)
+        if err != nil {
+                return 0
+        }
+        return b[0]
+}
+
+// bufferedConn reads through a bufio.Reader that may hold peeked bytes.
+type bufferedConn struct {
+        net.Conn
+        r *bufio.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (p *fakeHTTPProxy) handle(conn net.Conn) {
+        defer conn.Close()
+        br := bufio.NewReader(conn)
+        req, err := http.ReadRequest(br)
+        if err != nil || req.Method != "CONNECT" {
+                return
+        }
+        status := p.status
+        if p.auth != "" && req.Header.Get("Proxy-Authorization") != p.auth {
+                status = "407 Proxy Authentication Required"
+        }
+        io.WriteString(conn, "HTTP/1.1 "+status+"\r\n\r\n")
+        if !strings.HasPrefix(status, "200") {
+                return
+        }
+        var client net.Conn = bufferedConn{conn, br}
+        var upstream net.Conn
+        rewrite := func(b []byte) []byte { return b }
+        // Only an intercepting proxy waits for the client to speak first.
+        if p.rewrite != nil && peekByte(br) == 0x16 {
+                cert, err := selfSignedCert("127.0.0.1")
+                if err != nil {
+                        return
+                }
+                client = tls.Server(client, &tls.Config{Certificates: []tls.Certificate{cert}})
+                rewrite = p.rewrite
+                upstream, err = tls.Dial("tcp", req.Host, &tls.Config{InsecureSkipVerify: true})
+        } else {
+                upstream, err = net.Dial("tcp", req.Host)
+        }
+        if err != nil {
+                return
+        }
+        defer upstream.Close()
+        go func() {
+                io.Copy(client, upstream)
+                client.Close()
+        }()
+        buf := make([]byte, 4096)
+        for {
+                n, err := client.Read(buf)
+                if n > 0 {
+                        upstream.Write(rewrite(buf[:n]))
+                }
+                if err != nil {
+                        return
+                }
+        }
+}
+
+// useProxy routes connections through conf for one test.
+func useProxy(t *testing.T, conf *proxyConfig) {
+        saved := upstreamProxy
+        t.Cleanup(func() { upstreamProxy = saved })
+        upstreamProxy = conf
+}
+
+// TestVerifyProxyIntegrity checks rewriting is caught on the TLS path even
+// when plain tunnels are left alone.
+func TestVerifyProxyIntegrity(t *testing.T) {
+        savedIdle := echoIdle
+        t.Cleanup(func() { echoIdle = savedIdle })
+        echoIdle = 50 * time.Millisecond
+
+        addr := startFakeHTTPProxy(t, &fakeHTTPProxy{status: "200 Connection established"})
+        useProxy(t, &proxyConfig{Scheme: "http", Addr: addr})
+        altered, err := verifyProxyIntegrity("127.0.0.1:0", 2*time.Second)
+        if err != nil || len(altered) != 0 {
+                t.Fatalf("pass-through proxy: got %v, error %v", altered, err)
+        }
+
+        addr = startFakeHTTPProxy(t, &fakeHTTPProxy{
+                status: "200 Connection established",
+                rewrite: func(b []byte) []byte {
+                        return bytes.ReplaceAll(b, []byte("\x0b"), []byte(" "))
+                },
+        })
+        useProxy(t, &proxyConfig{Scheme: "http", Addr: addr})
+        altered, err = verifyProxyIntegrity("127.0.0.1:0", 2*time.Second)
+        if err != nil || len(altered) != 1 || !strings.HasPrefix(altered[0], "vertprefix1 (https): rewritten") {
+                t.Errorf("intercepting proxy: got %q, error %v", altered, err)
+        }
+
+        // The samples are built-in gadgets and survive a --mutations file.
+        saved := customGadgets
+        t.Cleanup(func() { customGadgets = saved })
+        customGadgets = map[string]string{"mine": "Transfer-Encoding: chunked"}
+        altered, err = verifyProxyIntegrity("127.0.0.1:0", 2*time.Second)
+        if err != nil || len(altered) != 1 {
+                t.Errorf("with --mutations: got %q, error %v", altered, err)
+        }
+}
+
+// startGreeter accepts connections and writes "ok" to each, so a test can
+// tell a tunnel reached it.
+func startGreeter(t *testing.T) (string, int) {
+        ln, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { ln.Close() })
+        go func() {
+                for {
+                        conn, err := ln.Accept()
+                        if err != nil {
+                                return
+                        }
+                        io.WriteString(conn, "ok")
+                        conn.Close()
+                }
+        }()
+        a := ln.Addr().(*net.TCPAddr)
+        return a.IP.String(), a.Port
+}
+
+// assertGreeted reads the greeting through conn.
+func assertGreeted(t *testing.T, conn net.Conn) {
+        t.Helper()
+        defer conn.Close()
+        conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+        got, err := io.ReadAll(conn)
+        if string(got) != "ok" {
+                t.Errorf("tunnel carried %q (%v), want \"ok\"", got, err)
+        }
+}
+
+func TestDialHTTPProxy(t *testing.T) {
+        host, port := startGreeter(t)
+        creds := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+        tests := []struct {
+                name    string
+                proxy   fakeHTTPProxy
+                user    string
+                pass    string
+                wantErr string
+        }{
+                {"open", fakeHTTPProxy{status: "200 Connection established"}, "", "", ""},
+                {"auth success", fakeHTTPProxy{status: "200 OK", auth: creds}, "user", "secret", ""},
+                {"auth failure", fakeHTTPProxy{status: "200 OK", auth: creds}, "user", "wrong", "proxy authentication required"},
+                {"forbidden", fakeHTTPProxy{status: "403 Forbidden"}, "", "", "proxy CONNECT failed: HTTP/1.1 403 Forbidden"},
+        }
+        for _, tt := range tests {
+                tt := tt
+                t.Run(tt.name, func(t *testing.T) {
+                        conf := &proxyConfig{Scheme: "http", Addr: startFakeHTTPProxy(t, &tt.proxy), User: tt.user, Pass: tt.pass}
+                        conn, err := dialHTTPProxy(conf, host, port, 2*time.Second)
+                        if tt.wantErr != "" {
+                                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                                        t.Fatalf("got error %v, want %q", err, tt.wantErr)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        assertGreeted(t, conn)
+                })
+        }
+}
+
+// fakeSOCKS5 is a single-connection SOCKS5 server. It requires user and
+// pass when user is set, answers CONNECT with reply and a bound address of
+// type bindAtyp, then writes "ok" into the tunnel. The requested
+// destination is sent on dest as "atyp host:port".
+type fakeSOCKS5 struct {
+        user     string
+        pass     string
+        reply    byte
+        bindAtyp byte
+        dest     chan string
+}
+
+func startFakeSOCKS5(t *testing.T, s *fakeSOCKS5) string {
+        ln, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { ln.Close() })
+        s.dest = make(chan string, 1)
+        go func() {
+                conn, err := ln.Accept()
+                if err != nil {
+                        return
+                }
+                defer conn.Close()
+                s.serve(conn)
+        }()
+        return ln.Addr().String()
+}
+
+func (s *fakeSOCKS5) serve(conn net.Conn) {
+        head := make([]byte, 2)
+        if _, err := io.ReadFull(conn, head); err != nil {
+                return
+        }
+        methods := make([]byte, head[1])
+        if _, err := io.ReadFull(conn, methods); err != nil {
+                return
+        }
+        want := byte(socksAuthNone)
+        if s.user != "" {
+                want = socksAuthPassword
+        }
+        if !bytes.Contains(methods, []byte{want}) {
+                conn.Write([]byte{socksVersion, socksAuthNoAccept})
+                return
+        }
+        conn.Write([]byte{socksVersion, want})
+        if s.user != "" {
+                user, pass := readSOCKSField(conn, 1), readSOCKSField(conn, 0)
+                if user != s.user || pass != s.pass {
+                        conn.Write([]byte{0x01, 0x01})
+                        return
+                }
+                conn.Write([]byte{0x01, 0x00})
+        }
+
+        req := make([]byte, 4)
+        if _, err := io.ReadFull(conn, req); err != nil {
+                return
+        }
+        var host string
+        switch req[3] {
+        case socksAtypIPv4:
+                ip := make([]byte, net.IPv4len)
+                io.ReadFull(conn, ip)
+                host = "ipv4 " + net.IP(ip).String()
+        case socksAtypIPv6:
+                ip := make([]byte, net.IPv6len)
+                io.ReadFull(conn, ip)
+                host = "ipv6 " + net.IP(ip).String()
+        case socksAtypDomain:
+                host = "domain " + readSOCKSField(conn, 0)
+        }
+        port := make([]byte, 2)
+        io.ReadFull(conn, port)
+        s.dest <- host + ":" + strconv.Itoa(int(binary.BigEndian.Uint16(port)))
+
+        reply := []byte{socksVersion, s.reply, 0x00, s.bindAtyp}
+        switch s.bindAtyp {
+        case socksAtypIPv4:
+                reply = append(reply, 10, 0, 0, 1)
+        case socksAtypIPv6:
+                reply = append(reply, net.ParseIP("fd00::1")...)
+        case socksAtypDomain:
+                reply = append(reply, 11)
+                reply = append(reply, "proxy.local"...)
+        }
+        reply = append(reply, 0x04, 0x38)
+        conn.Write(reply)
+        if s.reply == 0x00 {
+                io.WriteString(conn, "ok")
+        }
+}
+
+// readSOCKSField reads a length-prefixed field, first skipping skip bytes.
+func readSOCKSField(conn net.Conn, skip int) string {
+        b := make([]byte, skip+1)
+        if _, err := io.ReadFull(conn, b); err != nil {
+                return ""
+        }
+        field := make([]byte, b[skip])
+        io.ReadFull(conn, field)
+        return string(field)
+}
+
+func TestDialSOCKS5(t *testing.T) {
+        useResolver(t, stubResolver{"target.test": {net.ParseIP("192.0.2.7")}})
+        tests := []struct {
+                name     string
+                server   fakeSOCKS5
+                conf     proxyConfig
+                host     string
+                wantDest string
+                wantErr  string
+        }{
+                {"ipv4 target, ipv4 bind", fakeSOCKS5{bindAtyp: socksAtypIPv4}, proxyConfig{Scheme: "socks5"}, "192.0.2.1", "ipv4 192.0.2.1:80", ""},
+                {"ipv6 target, ipv6 bind", fakeSOCKS5{bindAtyp: socksAtypIPv6}, proxyConfig{Scheme: "socks5"}, "2001:db8::1", "ipv6 2001:db8::1:80", ""},
+                {"resolved locally", fakeSOCKS5{bindAtyp: socksAtypIPv4}, proxyConfig{Scheme: "socks5"}, "target.test", "ipv4 192.0.2.7:80", ""},
+                {"resolved by proxy, domain bind", fakeSOCKS5{bindAtyp: socksAtypDomain}, proxyConfig{Scheme: "socks5h"}, "target.test", "domain target.test:80", ""},
+                {"auth success", fakeSOCKS5{user: "user", pass: "secret", bindAtyp: socksAtypIPv4}, proxyConfig{Scheme: "socks5h", User: "user", Pass: "secret"}, "target.test", "domain target.test:80", ""},
+                {"auth failure", fakeSOCKS5{user: "user", pass: "secret"}, proxyConfig{Scheme: "socks5h", User: "user", Pass: "wrong"}, "target.test", "", "SOCKS authentication failed"},
+                {"auth not offered", fakeSOCKS5{user: "user", pass: "secret"}, proxyConfig{Scheme: "socks5h"}, "target.test", "", "accepted none of the offered auth methods"},
+                {"connect refused", fakeSOCKS5{reply: 0x05, bindAtyp: socksAtypIPv4}, proxyConfig{Scheme: "socks5h"}, "target.test", "domain target.test:80", "SOCKS CONNECT failed: connection refused"},
+        }
+        for _, tt := range tests {
+                tt := tt
+                t.Run(tt.name, func(t *testing.T) {
+                        conf := tt.conf
+                        conf.Addr = startFakeSOCKS5(t, &tt.server)
+                        conn, err := dialSOCKS5(&conf, tt.host, 80, 2*time.Second)
+                        if tt.wantDest != "" {
+                                select {
+                                case dest := <-tt.server.dest:
+                                        if dest != tt.wantDest {
+                                                t.Errorf("proxy asked for %q, want %q", dest, tt.wantDest)
+                                        }
+                                case <-time.After(2 * time.Second):
+                                        t.Errorf("proxy received no CONNECT request")
+                                }
+                        }
+                        if tt.wantErr != "" {
+                                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                                        t.Fatalf("got error %v, want %q", err, tt.wantErr)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        // The bound address must be consumed in full for the greeting
+                        // to arrive intact.
+                        assertGreeted(t, conn)
+                })
+        }
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+        "math/rand"
+        "net"
+        "time"
+)
+
+// ------------------------------
+// Randomness and time sources
+
+// rng is the source of all payload randomness, including cache-busters.
+// --seed replaces it so a scan's payloads can be reproduced.
+var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// seedRandom makes every subsequent payload deterministic for seed.
+func seedRandom(seed int64) {
+        rng = rand.New(rand.NewSource(seed))
+}
+
+// Clock tells the time used to measure responses. It is an interface so that
+// timing classification can be exercised with a fake clock.
+type Clock interface {
+        Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+        return time.Now()
+}
+
+// clock is used for all response timings. Socket deadlines always use the
+// real time.
+var clock Clock = systemClock{}
+
+// Read outcomes reported by Desyncr.test.
+const (
+        readError        = -1
+        readOK           = 0
+        readTimeout      = 1
+        readDisconnected = 2
+)
+
+// classifyRead maps the result of waiting for a response onto a read outcome.
+// A timeout that fires well before the configured timeout means the peer
+// went away rather than stalled.
+func classifyRead(err error, elapsed, timeout time.Duration) int {
+        if err == nil {
+                return readOK
+        }
+        if ne, ok := err.(net.Error); ok && ne.Timeout() {
+                if elapsed < timeout-time.Second {
+                        return readDisconnected
+                }
+                return readTimeout
+        }
+        return readError
+}
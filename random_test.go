@@ -0,0 +1,94 @@
+package main
+
+import (
+        "errors"
+        "testing"
+        "time"
+)
+
+// fakeClock advances by step every time it is read.
+type fakeClock struct {
+        now  time.Time
+        step time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+        c.now = c.now.Add(c.step)
+        return c.now
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestClassifyRead(t *testing.T) {
+        tests := []struct {
+                err     error
+                elapsed time.Duration
+                want    int
+        }{
+                {nil, time.Second, readOK},
+                {timeoutErr{}, 5 * time.Second, readTimeout},
+                {timeoutErr{}, 4500 * time.Millisecond, readTimeout},
+                {timeoutErr{}, 3 * time.Second, readDisconnected},
+                {errors.New("connection reset"), time.Second, readError},
+        }
+        for _, tt := range tests {
+                if got := classifyRead(tt.err, tt.elapsed, 5*time.Second); got != tt.want {
+                        t.Errorf("classifyRead(%v, %v): got %d, want %d", tt.err, tt.elapsed, got, tt.want)
+                }
+        }
+}
+
+// TestTestUsesClock checks Desyncr.test measures the stalled read with the
+// injected clock rather than the wall clock.
+func TestTestUsesClock(t *testing.T) {
+        presets := simulatorPresets["clte"]
+        s, err := startSimulator(presets[0], presets[1])
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer s.Close()
+        d := simDesyncr(s)
+        d.timeout = 1100 * time.Millisecond
+        p := renderTemplate("Transfer-Encoding: chunked")
+
+        saved := clock
+        t.Cleanup(func() { clock = saved })
+        for _, tt := range []struct {
+                step time.Duration
+                want int
+        }{
+                {0, readDisconnected},
+                {time.Minute, readTimeout},
+        } {
+                clock = &fakeClock{step: tt.step}
+                if code, _, _ := d.checkCLTE(p, 0); code != tt.want {
+                        t.Errorf("clock step %v: got code %d, want %d", tt.step, code, tt.want)
+                }
+        }
+}
+
+// keepRandom restores the global rng once the test has reseeded it.
+func keepRandom(t *testing.T) {
+        saved := rng
+        t.Cleanup(func() { rng = saved })
+}
+
+func TestSeedReproducible(t *testing.T) {
+        keepRandom(t)
+        render := func(seed int64) string {
+                seedRandom(seed)
+                p := renderTemplate("Transfer-Encoding: chunked")
+                p.Host = "example.com"
+                return p.String() + randomString(8)
+        }
+        if render(42) != render(42) {
+                t.Errorf("same seed rendered different payloads")
+        }
+        if render(42) == render(43) {
+                t.Errorf("different seeds rendered the same payload")
+        }
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+        "fmt"
+        "io"
+        "net"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// ------------------------------
+// Connection target overrides
+
+// connectOverride redirects connections for Host:Port to ConnHost:ConnPort
+// while Host header and SNI keep using the original name. An empty Host or a
+// zero Port matches anything; an empty ConnHost or zero ConnPort keeps the
+// original value.
+type connectOverride struct {
+        Host     string
+        Port     int
+        ConnHost string
+        ConnPort int
+}
+
+// connectToOverrides and resolveOverrides are filled from --connect-to and
+// --resolve. Within each list the first match wins.
+var (
+        connectToOverrides []connectOverride
+        resolveOverrides   []connectOverride
+)
+
+// splitSpec splits a colon separated option value, keeping bracketed IPv6
+// literals intact.
+func splitSpec(spec string) []string {
+        var fields []string
+        var cur strings.Builder
+        inBracket := false
+        for _, r := range spec {
+                switch {
+                case r == '[':
+                        inBracket = true
+                case r == ']':
+                        inBracket = false
+                case r == ':' && !inBracket:
+                        fields = append(fields, cur.String())
+                        cur.Reset()
+                        continue
+                }
+                cur.WriteRune(r)
+        }
+        return append(fields, cur.String())
+}
+
+func parseSpecPort(s string) (int, error) {
+        if s == "" {
+                return 0, nil
+        }
+        port, err := strconv.Atoi(s)
+        if err != nil || port < 1 || port > 65535 {
+                return 0, fmt.Errorf("invalid port: %s", s)
+        }
+        return port, nil
+}
+
+// parseResolve parses a curl style --resolve value, "host:port:address".
+func parseResolve(spec string) (connectOverride, error) {
+        fields := splitSpec(spec)
+        if len(fields) != 3 || fields[0] == "" || fields[2] == "" {
+                return connectOverride{}, fmt.Errorf("invalid --resolve value %q, want host:port:address", spec)
+        }
+        port, err := parseSpecPort(fields[1])
+        if err != nil || port == 0 {
+                return connectOverride{}, fmt.Errorf("invalid --resolve value %q, want host:port:address", spec)
+        }
+        addr := strings.Trim(fields[2], "[]")
+        if net.ParseIP(addr) == nil {
+                return connectOverride{}, fmt.Errorf("invalid --resolve address: %s", fields[2])
+        }
+        return connectOverride{Host: strings.Trim(fields[0], "[]"), Port: port, ConnHost: addr}, nil
+}
+
+// parseConnectTo parses a curl style --connect-to value,
+// "host:port:connect_host:connect_port", where any field may be empty.
+func parseConnectTo(spec string) (connectOverride, error) {
+        fields := splitSpec(spec)
+        if len(fields) != 4 {
+                return connectOverride{}, fmt.Errorf("invalid --connect-to value %q, want host:port:connect_host:connect_port", spec)
+        }
+        port, err := parseSpecPort(fields[1])
+        if err != nil {
+                return connectOverride{}, err
+        }
+        connPort, err := parseSpecPort(fields[3])
+        if err != nil {
+                return connectOverride{}, err
+        }
+        return connectOverride{
+                Host:     strings.Trim(fields[0], "[]"),
+                Port:     port,
+                ConnHost: strings.Trim(fields[2], "[]"),
+                ConnPort: connPort,
+        }, nil
+}
+
+// dialAddress returns the host and port a connection to host:port should
+// actually be made to. As with curl, --connect-to is applied first and
+// --resolve then pins the resulting name to an address.
+func dialAddress(host string, port int) (string, int) {
+        host, port = applyOverride(connectToOverrides, host, port)
+        return applyOverride(resolveOverrides, host, port)
+}
+
+func applyOverride(overrides []connectOverride, host string, port int) (string, int) {
+        for _, o := range overrides {
+                if (o.Host != "" && !strings.EqualFold(o.Host, host)) || (o.Port != 0 && o.Port != port) {
+                        continue
+                }
+                if o.ConnHost != "" {
+                        host = o.ConnHost
+                }
+                if o.ConnPort != 0 {
+                        port = o.ConnPort
+                }
+                break
+        }
+        return host, port
+}
+
+// targetIP describes the address connections to host:port end up at, for
+// display in banners and results.
+func targetIP(host string, port int) string {
+        dialHost, dialPort := dialAddress(host, port)
+        addrs, err := lookupHost(dialHost)
+        if err != nil {
+                return "unresolved"
+        }
+        dialHost = addrs[0].String()
+        if dialPort != port {
+                return fmt.Sprintf("%s port %d", dialHost, dialPort)
+        }
+        return dialHost
+}
+
+// ------------------------------
+// Address families
+
+var (
+        // ipPreference puts "4" or "6" addresses first when a name has both.
+        ipPreference string
+        // ipOnly restricts connections to "4" or "6" addresses when set.
+        ipOnly string
+)
+
+// lookupHost resolves host to its addresses, filtered by ipOnly and ordered
+// by ipPreference. IP literals are returned as is.
+func lookupHost(host string) ([]net.IP, error) {
+        if ip := net.ParseIP(host); ip != nil {
+                return []net.IP{ip}, nil
+        }
+        addrs, err := resolver.LookupIP(host)
+        if err != nil {
+                return nil, err
+        }
+        var first, second []net.IP
+        for _, ip := range addrs {
+                family := "6"
+                if ip.To4() != nil {
+                        family = "4"
+                }
+                if ipOnly != "" && family != ipOnly {
+                        continue
+                }
+                if ipPreference == "" || family == ipPreference {
+                        first = append(first, ip)
+                } else {
+                        second = append(second, ip)
+                }
+        }
+        addrs = append(first, second...)
+        if len(addrs) == 0 {
+                return nil, fmt.Errorf("no IPv%s address for %s", ipOnly, host)
+        }
+        return addrs, nil
+}
+
+// dialTCP connects to host:port, trying each resolved address in order.
+func dialTCP(host string, port int, timeout time.Duration) (net.Conn, error) {
+        addrs, err := lookupHost(host)
+        if err != nil {
+                return nil, err
+        }
+        var conn net.Conn
+        for _, ip := range addrs {
+                conn, err = net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), timeout)
+                if err == nil {
+                        return conn, nil
+                }
+        }
+        return nil, err
+}
+
+// formatHostHeader renders host and port for a Host header or URL: IPv6
+// literals are bracketed and the port is omitted when it is the scheme
+// default.
+func formatHostHeader(host string, port int, sslFlag bool) string {
+        if strings.Contains(host, ":") {
+                host = "[" + host + "]"
+        }
+        if (sslFlag && port == 443) || (!sslFlag && port == 80) {
+                return host
+        }
+        return host + ":" + strconv.Itoa(port)
+}
+
+// ------------------------------
+// Multi-address targets
+
+// Resolver looks up every address of a host name. It is an interface so
+// that node enumeration can be exercised with a stub.
+type Resolver interface {
+        LookupIP(host string) ([]net.IP, error)
+}
+
+type systemResolver struct{}
+
+func (systemResolver) LookupIP(host string) ([]net.IP, error) {
+        return net.LookupIP(host)
+}
+
+// resolver is used for all name lookups.
+var resolver Resolver = systemResolver{}
+
+// allTargetIPs returns every address, A and AAAA, the target's connections
+// could end up at, honouring overrides and the address family options.
+func allTargetIPs(host string, port int) ([]string, error) {
+        dialHost, _ := dialAddress(host, port)
+        addrs, err := lookupHost(dialHost)
+        if err != nil {
+                return nil, err
+        }
+        var ips []string
+        seen := make(map[string]bool)
+        for _, ip := range addrs {
+                if !seen[ip.String()] {
+                        seen[ip.String()] = true
+                        ips = append(ips, ip.String())
+                }
+        }
+        return ips, nil
+}
+
+// printNodeSummary lists the findings per address and calls out nodes that
+// behaved differently from the rest.
+func printNodeSummary(nodes []string, findings map[string][]string, logh io.Writer) {
+        consistent := true
+        baseline := strings.Join(sortedCopy(findings[nodes[0]]), ",")
+        for _, node := range nodes {
+                list := sortedCopy(findings[node])
+                msg := "none"
+                if len(list) > 0 {
+                        msg = strings.Join(list, ", ")
+                }
+                if strings.Join(list, ",") != baseline {
+                        consistent = false
+                }
+                printInfo(fmt.Sprintf("Node %-15s: %s", node, ColorCyan+msg+ColorMagenta), logh)
+        }
+        if !consistent {
+                printInfo(ColorYellow+"Inconsistent results across nodes, front-ends behind this name differ"+ColorMagenta, logh)
+        }
+}
+
+func sortedCopy(list []string) []string {
+        out := append([]string(nil), list...)
+        sort.Strings(out)
+        return out
+}
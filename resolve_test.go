@@ -0,0 +1,166 @@
+package main
+
+import (
+        "net"
+        "reflect"
+        "testing"
+)
+
+// stubResolver answers every lookup from a fixed table.
+type stubResolver map[string][]net.IP
+
+func (s stubResolver) LookupIP(host string) ([]net.IP, error) {
+        if ips, ok := s[host]; ok {
+                return ips, nil
+        }
+        return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// useResolver installs r and clears the address family options for one test.
+func useResolver(t *testing.T, r Resolver) {
+        savedResolver, savedOnly, savedPref, savedResolve := resolver, ipOnly, ipPreference, resolveOverrides
+        t.Cleanup(func() {
+                resolver, ipOnly, ipPreference, resolveOverrides = savedResolver, savedOnly, savedPref, savedResolve
+        })
+        resolver, ipOnly, ipPreference, resolveOverrides = r, "", "", nil
+}
+
+func TestLookupHostFamilies(t *testing.T) {
+        useResolver(t, stubResolver{
+                "dual.test": {net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::2"), net.ParseIP("192.0.2.2")},
+                "v4.test":   {net.ParseIP("192.0.2.1")},
+        })
+        tests := []struct {
+                host, only, pref string
+                want             []string
+                ok               bool
+        }{
+                {"dual.test", "", "", []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}, true},
+                {"dual.test", "", "4", []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"}, true},
+                {"dual.test", "", "6", []string{"2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2"}, true},
+                {"dual.test", "4", "", []string{"192.0.2.1", "192.0.2.2"}, true},
+                {"dual.test", "6", "4", []string{"2001:db8::1", "2001:db8::2"}, true},
+                {"v4.test", "6", "", nil, false},
+                {"missing.test", "", "", nil, false},
+                {"2001:db8::9", "4", "", []string{"2001:db8::9"}, true},
+        }
+        for _, tt := range tests {
+                ipOnly, ipPreference = tt.only, tt.pref
+                addrs, err := lookupHost(tt.host)
+                var got []string
+                for _, ip := range addrs {
+                        got = append(got, ip.String())
+                }
+                if (err == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+                        t.Errorf("%s only=%q pref=%q: got %v, error %v", tt.host, tt.only, tt.pref, got, err)
+                }
+        }
+}
+
+func TestAllTargetIPs(t *testing.T) {
+        useResolver(t, stubResolver{
+                "multi.test": {net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.3")},
+        })
+        ipPreference = "4"
+        got, err := allTargetIPs("multi.test", 443)
+        if want := []string{"192.0.2.1", "192.0.2.3", "2001:db8::1"}; err != nil || !reflect.DeepEqual(got, want) {
+                t.Errorf("got nodes %v, error %v, want %v", got, err, want)
+        }
+
+        resolveOverrides = []connectOverride{{Host: "multi.test", Port: 443, ConnHost: "198.51.100.7"}}
+        if got, err := allTargetIPs("multi.test", 443); err != nil || !reflect.DeepEqual(got, []string{"198.51.100.7"}) {
+                t.Errorf("pinned: got nodes %v, error %v", got, err)
+        }
+        if _, err := allTargetIPs("missing.test", 443); err == nil {
+                t.Errorf("unresolvable host expanded to nodes")
+        }
+}
+
+func TestFormatHostHeader(t *testing.T) {
+        tests := []struct {
+                host string
+                port int
+                ssl  bool
+                want string
+        }{
+                {"example.com", 80, false, "example.com"},
+                {"example.com", 443, true, "example.com"},
+                {"example.com", 443, false, "example.com:443"},
+                {"example.com", 8443, true, "example.com:8443"},
+                {"2001:db8::1", 443, true, "[2001:db8::1]"},
+                {"2001:db8::1", 8080, false, "[2001:db8::1]:8080"},
+                {"::1", 80, true, "[::1]:80"},
+                {"192.0.2.1", 8000, false, "192.0.2.1:8000"},
+        }
+        for _, tt := range tests {
+                if got := formatHostHeader(tt.host, tt.port, tt.ssl); got != tt.want {
+                        t.Errorf("formatHostHeader(%q, %d, %v) = %q, want %q", tt.host, tt.port, tt.ssl, got, tt.want)
+                }
+        }
+}
+
+func TestSplitSpec(t *testing.T) {
+        tests := []struct {
+                spec string
+                want []string
+        }{
+                {"a.test:443:192.0.2.1", []string{"a.test", "443", "192.0.2.1"}},
+                {"a.test:443:[2001:db8::1]", []string{"a.test", "443", "[2001:db8::1]"}},
+                {"[::1]:80:[2001:db8::1]:8080", []string{"[::1]", "80", "[2001:db8::1]", "8080"}},
+                {"::b.test:", []string{"", "", "b.test", ""}},
+                {"", []string{""}},
+        }
+        for _, tt := range tests {
+                if got := splitSpec(tt.spec); !reflect.DeepEqual(got, tt.want) {
+                        t.Errorf("splitSpec(%q): got %q, want %q", tt.spec, got, tt.want)
+                }
+        }
+}
+
+func TestParseResolve(t *testing.T) {
+        tests := []struct {
+                spec    string
+                want    connectOverride
+                wantErr bool
+        }{
+                {spec: "a.test:443:192.0.2.1", want: connectOverride{Host: "a.test", Port: 443, ConnHost: "192.0.2.1"}},
+                {spec: "a.test:80:[2001:db8::1]", want: connectOverride{Host: "a.test", Port: 80, ConnHost: "2001:db8::1"}},
+                {spec: "a.test:80:2001:db8::1", wantErr: true},
+                {spec: "[2001:db8::5]:443:192.0.2.1", want: connectOverride{Host: "2001:db8::5", Port: 443, ConnHost: "192.0.2.1"}},
+                {spec: "a.test::192.0.2.1", wantErr: true},
+                {spec: "a.test:0:192.0.2.1", wantErr: true},
+                {spec: ":443:192.0.2.1", wantErr: true},
+                {spec: "a.test:443:", wantErr: true},
+                {spec: "a.test:443:b.test", wantErr: true},
+                {spec: "a.test:443", wantErr: true},
+        }
+        for _, tt := range tests {
+                got, err := parseResolve(tt.spec)
+                if (err != nil) != tt.wantErr || got != tt.want {
+                        t.Errorf("parseResolve(%q): got %+v, %v", tt.spec, got, err)
+                }
+        }
+}
+
+func TestParseConnectTo(t *testing.T) {
+        tests := []struct {
+                spec    string
+                want    connectOverride
+                wantErr bool
+        }{
+                {spec: "a.test:443:b.test:8443", want: connectOverride{Host: "a.test", Port: 443, ConnHost: "b.test", ConnPort: 8443}},
+                {spec: "::b.test:", want: connectOverride{ConnHost: "b.test"}},
+                {spec: "a.test:::8443", want: connectOverride{Host: "a.test", ConnPort: 8443}},
+                {spec: "[2001:db8::5]:80:[::1]:8080", want: connectOverride{Host: "2001:db8::5", Port: 80, ConnHost: "::1", ConnPort: 8080}},
+                {spec: "a.test:443:b.test", wantErr: true},
+                {spec: "a.test:https:b.test:443", wantErr: true},
+                {spec: "a.test:443:b.test:70000", wantErr: true},
+                {spec: "2001:db8::5:80::", wantErr: true},
+        }
+        for _, tt := range tests {
+                got, err := parseConnectTo(tt.spec)
+                if (err != nil) != tt.wantErr || got != tt.want {
+                        t.Errorf("parseConnectTo(%q): got %+v, %v", tt.spec, got, err)
+                }
+        }
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+        "crypto/tls"
+        "fmt"
+        "net"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// ------------------------------
+// Segmented payload delivery
+
+// SplitMarker may be placed anywhere in a Payload's Header or Body to force a
+// segment boundary at that point. It is stripped from the bytes sent.
+const SplitMarker = "__SPLIT__"
+
+// Segment is a single write of a payload on the wire.
+type Segment struct {
+        Data  string
+        Delay time.Duration // pause before this segment is written
+}
+
+// SegmentPlan describes how a rendered payload is cut into writes.
+type SegmentPlan struct {
+        Mode    string        // "single", "headers" or "bytes"
+        Size    int           // segment size for "bytes"
+        Delay   time.Duration // pause between consecutive segments
+        NoDelay bool          // TCP_NODELAY on the underlying socket
+}
+
+// defaultSegmentPlan keeps the historical behaviour of one write per payload.
+func defaultSegmentPlan() SegmentPlan {
+        return SegmentPlan{Mode: "single", NoDelay: true}
+}
+
+// parseSegmentMode accepts "single", "headers" or a byte count.
+func parseSegmentMode(mode string, plan *SegmentPlan) error {
+        switch mode {
+        case "single", "headers":
+                plan.Mode = mode
+                return nil
+        }
+        n, err := strconv.Atoi(mode)
+        if err != nil || n <= 0 {
+                return fmt.Errorf("invalid segment mode: %s", mode)
+        }
+        plan.Mode = "bytes"
+        plan.Size = n
+        return nil
+}
+
+// Segments renders the payload and cuts it according to plan. Split markers
+// embedded in the payload are always honoured.
+func (p *Payload) Segments(plan SegmentPlan) []Segment {
+        rendered := p.render(plan.Mode == "headers")
+        var parts []string
+        for _, part := range strings.Split(rendered, SplitMarker) {
+                if part == "" {
+                        continue
+                }
+                if plan.Mode == "bytes" && plan.Size > 0 {
+                        for len(part) > plan.Size {
+                                parts = append(parts, part[:plan.Size])
+                                part = part[plan.Size:]
+                        }
+                }
+                parts = append(parts, part)
+        }
+        segs := make([]Segment, len(parts))
+        for i, part := range parts {
+                segs[i].Data = part
+                if i > 0 {
+                        segs[i].Delay = plan.Delay
+                }
+        }
+        return segs
+}
+
+// writeSegments sends segs on conn in order, honouring delays. Each write
+// gets its own deadline of timeout, so the sum of the delays may exceed the
+// timeout the connection was opened with.
+func writeSegments(conn net.Conn, segs []Segment, plan SegmentPlan, timeout time.Duration) error {
+        setNoDelay(conn, plan.NoDelay)
+        for _, seg := range segs {
+                if seg.Delay > 0 {
+                        time.Sleep(seg.Delay)
+                }
+                conn.SetWriteDeadline(time.Now().Add(timeout))
+                if _, err := conn.Write([]byte(seg.Data)); err != nil {
+                        return err
+                }
+        }
+        return nil
+}
+
+// setNoDelay toggles TCP_NODELAY on conn, unwrapping TLS if needed.
+func setNoDelay(conn net.Conn, noDelay bool) {
+        for {
+                tlsConn, ok := conn.(*tls.Conn)
+                if !ok {
+                        break
+                }
+                conn = tlsConn.NetConn()
+        }
+        if tcpConn, ok := conn.(*net.TCPConn); ok {
+                tcpConn.SetNoDelay(noDelay)
+        }
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+        "io"
+        "net"
+        "reflect"
+        "testing"
+        "time"
+)
+
+func TestParseSegmentMode(t *testing.T) {
+        tests := []struct {
+                mode string
+                want SegmentPlan
+                ok   bool
+        }{
+                {"single", SegmentPlan{Mode: "single"}, true},
+                {"headers", SegmentPlan{Mode: "headers"}, true},
+                {"7", SegmentPlan{Mode: "bytes", Size: 7}, true},
+                {"0", SegmentPlan{}, false},
+                {"-3", SegmentPlan{}, false},
+                {"bytes", SegmentPlan{}, false},
+        }
+        for _, tt := range tests {
+                var plan SegmentPlan
+                err := parseSegmentMode(tt.mode, &plan)
+                if (err == nil) != tt.ok || (tt.ok && plan != tt.want) {
+                        t.Errorf("%s: got %+v, error %v", tt.mode, plan, err)
+                }
+        }
+}
+
+func TestPayloadSegments(t *testing.T) {
+        p := &Payload{Header: "GET / HTTP/1.1\r\nHost: __HOST__\r\n", Body: "ab" + SplitMarker + "cdefg", Host: "h", CL: -1}
+        delay := 10 * time.Millisecond
+        tests := []struct {
+                plan SegmentPlan
+                want []string
+        }{
+                {SegmentPlan{Mode: "single"}, []string{"GET / HTTP/1.1\r\nHost: h\r\n\r\nab", "cdefg"}},
+                {SegmentPlan{Mode: "headers"}, []string{"GET / HTTP/1.1\r\nHost: h\r\n\r\n", "ab", "cdefg"}},
+                {SegmentPlan{Mode: "bytes", Size: 16, Delay: delay}, []string{"GET / HTTP/1.1\r\n", "Host: h\r\n\r\nab", "cdefg"}},
+                {SegmentPlan{Mode: "bytes", Size: 3}, []string{"GET", " / ", "HTT", "P/1", ".1\r", "\nHo", "st:", " h\r", "\n\r\n", "ab", "cde", "fg"}},
+        }
+        for _, tt := range tests {
+                segs := p.Segments(tt.plan)
+                var got []string
+                for i, seg := range segs {
+                        got = append(got, seg.Data)
+                        if want := tt.plan.Delay; i == 0 && seg.Delay != 0 || i > 0 && seg.Delay != want {
+                                t.Errorf("%+v: segment %d has delay %v", tt.plan, i, seg.Delay)
+                        }
+                }
+                if !reflect.DeepEqual(got, tt.want) {
+                        t.Errorf("%+v: got %q, want %q", tt.plan, got, tt.want)
+                }
+        }
+}
+
+// TestWriteSegmentsSlow checks delays adding up to more than the deadline
+// the connection was opened with do not fail the writes.
+func TestWriteSegmentsSlow(t *testing.T) {
+        ln, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer ln.Close()
+        received := make(chan string, 1)
+        go func() {
+                c, err := ln.Accept()
+                if err != nil {
+                        return
+                }
+                defer c.Close()
+                data, _ := io.ReadAll(c)
+                received <- string(data)
+        }()
+        conn, err := net.Dial("tcp", ln.Addr().String())
+        if err != nil {
+                t.Fatal(err)
+        }
+        timeout := 100 * time.Millisecond
+        conn.SetDeadline(time.Now().Add(timeout))
+        plan := SegmentPlan{Mode: "bytes", Size: 1, Delay: 40 * time.Millisecond}
+        segs := []Segment{{Data: "a"}, {Data: "b", Delay: plan.Delay}, {Data: "c", Delay: plan.Delay}, {Data: "d", Delay: plan.Delay}}
+        if err := writeSegments(conn, segs, plan, timeout); err != nil {
+                t.Fatalf("write failed after %v of delays: %v", 3*plan.Delay, err)
+        }
+        conn.Close()
+        if got := <-received; got != "abcd" {
+                t.Errorf("received %q", got)
+        }
+}
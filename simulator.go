@@ -0,0 +1,474 @@
+package main
+
+import (
+        "bufio"
+        "fmt"
+        "io"
+        "net"
+        "os"
+        "os/signal"
+        "strconv"
+        "strings"
+        "sync"
+        "syscall"
+        "time"
+)
+
+// ------------------------------
+// Local front-end/back-end simulator
+
+// ParserConfig selects how one side of the simulator frames request bodies.
+// A recognised Transfer-Encoding wins over Content-Length; a request with
+// neither has no body.
+type ParserConfig struct {
+        HonourCL bool     // frame bodies by Content-Length
+        HonourTE bool     // frame bodies by a strictly parsed Transfer-Encoding: chunked
+        Tolerate []string // TE mutation names whose gadget is also read as chunked
+        Strip    []string // header names removed before forwarding, front-end only
+
+        // CLPolicy resolves several Content-Length headers: "" rejects
+        // conflicting or invalid values, "first" and "last" skip invalid ones
+        // and pick by position.
+        CLPolicy  string
+        LenientCL bool // accept "+N", trailing junk, comma lists and "Content-Length :"
+
+        // LenientChunks accepts bare LF line endings, whitespace after chunk
+        // sizes and sizes that overflow 64 bits, which wrap around.
+        LenientChunks bool
+
+        NoTE10 bool // ignore Transfer-Encoding in HTTP/1.0 requests
+}
+
+// simulatorPresets are the combinations available from --simulate.
+var simulatorPresets = map[string][2]ParserConfig{
+        "clte": {{HonourCL: true}, {HonourCL: true, HonourTE: true}},
+        "tecl": {{HonourCL: true, HonourTE: true}, {HonourCL: true}},
+        "safe": {{HonourCL: true, HonourTE: true}, {HonourCL: true, HonourTE: true}},
+}
+
+// maxSimHead caps the size of a request head the simulator will buffer.
+const maxSimHead = 64 * 1024
+
+var simMethods = map[string]bool{
+        "GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+        "OPTIONS": true, "PATCH": true, "TRACE": true, "CONNECT": true,
+}
+
+// simRequest is a request as read by one side of the simulator.
+type simRequest struct {
+        Method string
+        Head   string // request line and headers, including the blank line
+        Body   string // body bytes exactly as they were framed
+}
+
+// frameBody decides how the body of a request with the given head is
+// framed: "chunked", "length" with its size, or "none".
+func (c ParserConfig) frameBody(head string) (string, int64, error) {
+        all := strings.Split(strings.TrimSuffix(head, "\r\n\r\n"), "\r\n")
+        lines := all[1:]
+        if c.HonourTE && !(c.NoTE10 && strings.HasSuffix(all[0], " HTTP/1.0")) {
+                for _, line := range lines {
+                        if isChunkedTE(line) {
+                                return "chunked", 0, nil
+                        }
+                }
+                gadgets := teGadgets()
+                for _, name := range c.Tolerate {
+                        if strings.Contains(head, "\r\n"+gadgets[name]+"\r\n") {
+                                return "chunked", 0, nil
+                        }
+                }
+        }
+        if !c.HonourCL {
+                return "none", 0, nil
+        }
+        length := int64(-1)
+        for _, line := range lines {
+                idx := strings.Index(line, ":")
+                if idx < 0 {
+                        continue
+                }
+                name := line[:idx]
+                if c.LenientCL {
+                        name = strings.TrimRight(name, " \t")
+                }
+                if !strings.EqualFold(name, "Content-Length") {
+                        continue
+                }
+                n, ok := c.parseCL(line[idx+1:])
+                if !ok {
+                        if c.CLPolicy == "" {
+                                return "", 0, fmt.Errorf("invalid Content-Length: %q", line[idx+1:])
+                        }
+                        continue
+                }
+                switch {
+                case length < 0 || c.CLPolicy == "last":
+                        length = n
+                case c.CLPolicy == "first":
+                case n != length:
+                        return "", 0, fmt.Errorf("conflicting Content-Length headers")
+                }
+        }
+        if length < 0 {
+                return "none", 0, nil
+        }
+        return "length", length, nil
+}
+
+// parseCL parses a Content-Length value. Strictly that is digits with
+// optional whitespace, or a list of identical such values.
+func (c ParserConfig) parseCL(value string) (int64, bool) {
+        if c.LenientCL {
+                value = strings.TrimPrefix(strings.TrimSpace(value), "+")
+                end := len(value) - len(strings.TrimLeft(value, "0123456789"))
+                n, err := strconv.ParseInt(value[:end], 10, 64)
+                return n, err == nil
+        }
+        length := int64(-1)
+        for _, v := range strings.Split(value, ",") {
+                v = strings.Trim(v, " \t")
+                n, err := strconv.ParseInt(v, 10, 64)
+                if err != nil || n < 0 || strings.TrimLeft(v, "0123456789") != "" || (length >= 0 && n != length) {
+                        return 0, false
+                }
+                length = n
+        }
+        return length, true
+}
+
+// isChunkedTE reports whether line is a Transfer-Encoding header, without
+// whitespace in the name, whose final coding is chunked.
+func isChunkedTE(line string) bool {
+        idx := strings.Index(line, ":")
+        if idx < 0 || !strings.EqualFold(line[:idx], "Transfer-Encoding") {
+                return false
+        }
+        codings := strings.Split(line[idx+1:], ",")
+        return strings.EqualFold(strings.Trim(codings[len(codings)-1], " \t"), "chunked")
+}
+
+// readRequest reads one request from br, framing the body per c. io.EOF is
+// returned when the peer closed between requests.
+func (c ParserConfig) readRequest(br *bufio.Reader) (*simRequest, error) {
+        var head strings.Builder
+        for !strings.HasSuffix(head.String(), "\r\n\r\n") {
+                line, err := br.ReadString('\n')
+                if err != nil {
+                        if err == io.EOF && head.Len() == 0 && line == "" {
+                                return nil, io.EOF
+                        }
+                        return nil, fmt.Errorf("incomplete request head: %v", err)
+                }
+                if head.Len() == 0 && line == "\r\n" {
+                        // Empty lines before the request line are ignored.
+                        continue
+                }
+                head.WriteString(line)
+                if head.Len() > maxSimHead {
+                        return nil, fmt.Errorf("request head too large")
+                }
+        }
+        req := &simRequest{Head: head.String()}
+        req.Method = strings.SplitN(req.Head, " ", 2)[0]
+
+        framing, length, err := c.frameBody(req.Head)
+        if err != nil {
+                return nil, err
+        }
+        var body strings.Builder
+        switch framing {
+        case "length":
+                _, err = io.CopyN(&body, br, length)
+        case "chunked":
+                err = readChunked(br, &body, c.LenientChunks)
+        }
+        req.Body = body.String()
+        return req, err
+}
+
+// readChunked copies a chunked body to raw. Unless lenient, anything but
+// CRLF line endings and plain hex sizes is rejected.
+func readChunked(br *bufio.Reader, raw *strings.Builder, lenient bool) error {
+        readLine := func() (string, error) {
+                line, err := br.ReadString('\n')
+                raw.WriteString(line)
+                if err != nil {
+                        return "", err
+                }
+                if lenient {
+                        return strings.TrimRight(line, "\r\n"), nil
+                }
+                if !strings.HasSuffix(line, "\r\n") {
+                        return "", fmt.Errorf("bare LF in chunked body")
+                }
+                return strings.TrimSuffix(line, "\r\n"), nil
+        }
+        for {
+                line, err := readLine()
+                if err != nil {
+                        return err
+                }
+                sizeStr := line
+                if idx := strings.Index(sizeStr, ";"); idx >= 0 {
+                        sizeStr = sizeStr[:idx]
+                }
+                size, err := parseChunkSize(sizeStr, lenient)
+                if err != nil {
+                        return fmt.Errorf("malformed chunk size: %q", line)
+                }
+                if size == 0 {
+                        for {
+                                trailer, err := readLine()
+                                if err != nil || trailer == "" {
+                                        return err
+                                }
+                        }
+                }
+                if _, err = io.CopyN(raw, br, size); err != nil {
+                        return err
+                }
+                if line, err = readLine(); err != nil {
+                        return err
+                }
+                if line != "" {
+                        return fmt.Errorf("chunk data longer than its size")
+                }
+        }
+}
+
+// parseChunkSize parses a hex chunk size. A lenient parser ignores trailing
+// whitespace and keeps only the low 64 bits, as naive implementations do.
+func parseChunkSize(s string, lenient bool) (int64, error) {
+        if !lenient {
+                size, err := strconv.ParseInt(s, 16, 64)
+                if err == nil && (size < 0 || strings.HasPrefix(s, "+")) {
+                        err = fmt.Errorf("invalid chunk size")
+                }
+                return size, err
+        }
+        s = strings.TrimRight(s, " \t")
+        if s == "" {
+                return 0, fmt.Errorf("empty chunk size")
+        }
+        var size uint64
+        for _, r := range strings.ToLower(s) {
+                digit := strings.IndexRune("0123456789abcdef", r)
+                if digit < 0 {
+                        return 0, fmt.Errorf("invalid chunk size")
+                }
+                size = size<<4 | uint64(digit)
+        }
+        if int64(size) < 0 {
+                return 0, fmt.Errorf("chunk size too large")
+        }
+        return int64(size), nil
+}
+
+// stripHeaders removes the named headers from a request head.
+func stripHeaders(head string, names []string) string {
+        if len(names) == 0 {
+                return head
+        }
+        drop := make(map[string]bool)
+        for _, name := range names {
+                drop[strings.ToLower(name)] = true
+        }
+        lines := strings.Split(head, "\r\n")
+        kept := lines[:1]
+        for _, line := range lines[1:] {
+                if !drop[headerName(line)] {
+                        kept = append(kept, line)
+                }
+        }
+        return strings.Join(kept, "\r\n")
+}
+
+// Simulator is a front-end proxy and back-end server pair listening on
+// localhost. Each client connection gets its own persistent back-end
+// connection, so a desync affects only the connection that caused it.
+type Simulator struct {
+        Front          ParserConfig
+        Back           ParserConfig
+        BackendTimeout time.Duration // how long the front-end waits for a response
+
+        frontLn net.Listener
+        backLn  net.Listener
+        mu      sync.Mutex
+        conns   map[net.Conn]bool
+        wg      sync.WaitGroup
+}
+
+// startSimulator starts a simulator with the given parsers on random
+// localhost ports.
+func startSimulator(front, back ParserConfig) (*Simulator, error) {
+        gadgets := teGadgets()
+        for _, name := range append(append([]string(nil), front.Tolerate...), back.Tolerate...) {
+                if _, ok := gadgets[name]; !ok {
+                        return nil, fmt.Errorf("unknown TE mutation: %s", name)
+                }
+        }
+        s := &Simulator{
+                Front:          front,
+                Back:           back,
+                BackendTimeout: 10 * time.Second,
+                conns:          make(map[net.Conn]bool),
+        }
+        var err error
+        if s.backLn, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+                return nil, err
+        }
+        if s.frontLn, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+                s.backLn.Close()
+                return nil, err
+        }
+        s.wg.Add(2)
+        go s.serve(s.backLn, s.handleBack)
+        go s.serve(s.frontLn, s.handleFront)
+        return s, nil
+}
+
+// Addr is the front-end address clients should connect to.
+func (s *Simulator) Addr() string {
+        return s.frontLn.Addr().String()
+}
+
+// Close stops both listeners and drops every open connection.
+func (s *Simulator) Close() {
+        s.frontLn.Close()
+        s.backLn.Close()
+        s.mu.Lock()
+        for conn := range s.conns {
+                conn.Close()
+        }
+        s.mu.Unlock()
+        s.wg.Wait()
+}
+
+func (s *Simulator) track(conn net.Conn, open bool) {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        if open {
+                s.conns[conn] = true
+        } else {
+                delete(s.conns, conn)
+        }
+}
+
+func (s *Simulator) serve(ln net.Listener, handle func(net.Conn)) {
+        defer s.wg.Done()
+        for {
+                conn, err := ln.Accept()
+                if err != nil {
+                        return
+                }
+                s.track(conn, true)
+                s.wg.Add(1)
+                go func() {
+                        defer s.wg.Done()
+                        defer s.track(conn, false)
+                        defer conn.Close()
+                        handle(conn)
+                }()
+        }
+}
+
+func simResponse(status int, reason, body string) string {
+        return fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Length: %d\r\nContent-Type: text/plain\r\n\r\n%s", status, reason, len(body), body)
+}
+
+// handleBack answers each request on conn until the peer closes or a
+// request can't be parsed.
+func (s *Simulator) handleBack(conn net.Conn) {
+        br := bufio.NewReader(conn)
+        for {
+                req, err := s.Back.readRequest(br)
+                if err == io.EOF {
+                        return
+                }
+                if err != nil {
+                        io.WriteString(conn, simResponse(400, "Bad Request", err.Error()))
+                        return
+                }
+                parts := strings.SplitN(strings.SplitN(req.Head, "\r\n", 2)[0], " ", 3)
+                switch {
+                case len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/1."):
+                        io.WriteString(conn, simResponse(400, "Bad Request", "malformed request line"))
+                        return
+                case !simMethods[req.Method]:
+                        io.WriteString(conn, simResponse(405, "Method Not Allowed", "unrecognised method "+req.Method))
+                default:
+                        io.WriteString(conn, simResponse(200, "OK", "ok"))
+                }
+        }
+}
+
+// handleFront forwards each request on conn to the back-end over a single
+// persistent connection and relays the response.
+func (s *Simulator) handleFront(conn net.Conn) {
+        br := bufio.NewReader(conn)
+        var backConn net.Conn
+        var backReader *bufio.Reader
+        defer func() {
+                if backConn != nil {
+                        backConn.Close()
+                }
+        }()
+        for {
+                req, err := s.Front.readRequest(br)
+                if err == io.EOF {
+                        return
+                }
+                if err != nil {
+                        io.WriteString(conn, simResponse(400, "Bad Request", err.Error()))
+                        return
+                }
+                if backConn == nil {
+                        if backConn, err = net.Dial("tcp", s.backLn.Addr().String()); err != nil {
+                                io.WriteString(conn, simResponse(502, "Bad Gateway", err.Error()))
+                                return
+                        }
+                        s.track(backConn, true)
+                        defer s.track(backConn, false)
+                        backReader = bufio.NewReader(backConn)
+                }
+                if _, err = io.WriteString(backConn, stripHeaders(req.Head, s.Front.Strip)+req.Body); err != nil {
+                        io.WriteString(conn, simResponse(502, "Bad Gateway", err.Error()))
+                        return
+                }
+                backConn.SetReadDeadline(time.Now().Add(s.BackendTimeout))
+                resp, _, keepAlive, err := readResponse(backReader, req.Method)
+                if err != nil {
+                        if ne, ok := err.(net.Error); ok && ne.Timeout() {
+                                io.WriteString(conn, simResponse(504, "Gateway Timeout", "back-end timed out"))
+                        } else {
+                                io.WriteString(conn, simResponse(502, "Bad Gateway", err.Error()))
+                        }
+                        return
+                }
+                if _, err = io.WriteString(conn, resp); err != nil || !keepAlive {
+                        return
+                }
+        }
+}
+
+// runSimulator serves the named preset until SIGINT or SIGTERM and then
+// shuts it down. The mode only serves, it never goes on to scan.
+func runSimulator(preset string) error {
+        configs, ok := simulatorPresets[preset]
+        if !ok {
+                return fmt.Errorf("unknown simulator preset: %s (use clte, tecl or safe)", preset)
+        }
+        s, err := startSimulator(configs[0], configs[1])
+        if err != nil {
+                return err
+        }
+        printInfo("Simulator  : "+ColorCyan+preset+ColorMagenta+" front-end listening on "+ColorCyan+"http://"+s.Addr()+ColorMagenta, nil)
+        stop := make(chan os.Signal, 1)
+        signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+        defer signal.Stop(stop)
+        <-stop
+        printInfo("Simulator  : stopping", nil)
+        s.Close()
+        return nil
+}
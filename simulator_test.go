@@ -0,0 +1,92 @@
+package main
+
+import (
+        "net"
+        "strconv"
+        "testing"
+        "time"
+)
+
+func TestFrameBody(t *testing.T) {
+        strict := ParserConfig{HonourCL: true, HonourTE: true}
+        tests := []struct {
+                name    string
+                config  ParserConfig
+                headers string
+                framing string
+                length  int64
+        }{
+                {"cl", strict, "Content-Length: 5\r\n", "length", 5},
+                {"te wins", strict, "Transfer-Encoding: chunked\r\nContent-Length: 5\r\n", "chunked", 0},
+                {"te ows", strict, "Transfer-Encoding:\tchunked \r\nContent-Length: 5\r\n", "chunked", 0},
+                {"te last coding", strict, "Transfer-Encoding: chunked, cow\r\nContent-Length: 5\r\n", "length", 5},
+                {"te name space", strict, "Transfer-Encoding : chunked\r\nContent-Length: 5\r\n", "length", 5},
+                {"te ignored", ParserConfig{HonourCL: true}, "Transfer-Encoding: chunked\r\nContent-Length: 5\r\n", "length", 5},
+                {"tolerated", ParserConfig{HonourCL: true, HonourTE: true, Tolerate: []string{"space1"}}, "Transfer-Encoding : chunked\r\nContent-Length: 5\r\n", "chunked", 0},
+                {"no body", strict, "Host: x\r\n", "none", 0},
+        }
+        for _, tt := range tests {
+                framing, length, err := tt.config.frameBody("POST / HTTP/1.1\r\n" + tt.headers + "\r\n")
+                if err != nil {
+                        t.Errorf("%s: unexpected error %v", tt.name, err)
+                        continue
+                }
+                if framing != tt.framing || length != tt.length {
+                        t.Errorf("%s: got %s %d, want %s %d", tt.name, framing, length, tt.framing, tt.length)
+                }
+        }
+        if _, _, err := strict.frameBody("POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\n"); err == nil {
+                t.Errorf("conflicting Content-Length headers were accepted")
+        }
+}
+
+// simDesyncr returns a Desyncr aimed at the simulator's front-end.
+func simDesyncr(s *Simulator) *Desyncr {
+        host, portStr, _ := net.SplitHostPort(s.Addr())
+        port, _ := strconv.Atoi(portStr)
+        return &Desyncr{
+                host:     host,
+                port:     port,
+                ip:       host,
+                method:   "POST",
+                endpoint: "/",
+                timeout:  time.Second,
+                segments: defaultSegmentPlan(),
+        }
+}
+
+func TestSimulatorCLTE(t *testing.T) {
+        presets := simulatorPresets["clte"]
+        s, err := startSimulator(presets[0], presets[1])
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer s.Close()
+        d := simDesyncr(s)
+        p := renderTemplate("Transfer-Encoding: chunked")
+
+        if code, _, _ := d.checkCLTE(p, 0); code != 1 {
+                t.Errorf("CLTE with length 4: got code %d, want timeout", code)
+        }
+        if code, res, _ := d.checkCLTE(p, 1); code != 0 || extractStatusCode(res) != "200" {
+                t.Errorf("CLTE with length 11: got code %d response %q", code, res)
+        }
+}
+
+func TestSimulatorSafe(t *testing.T) {
+        presets := simulatorPresets["safe"]
+        s, err := startSimulator(presets[0], presets[1])
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer s.Close()
+        d := simDesyncr(s)
+        p := renderTemplate("Transfer-Encoding: chunked")
+
+        if code, res, _ := d.checkCLTE(p, 0); code != 0 || extractStatusCode(res) != "200" {
+                t.Errorf("CLTE: got code %d response %q", code, res)
+        }
+        if code, res, _ := d.checkTECL(p, 0); code != 0 || extractStatusCode(res) != "200" {
+                t.Errorf("TECL: got code %d response %q", code, res)
+        }
+}
@@ -1,4 +1,3 @@
-
 package main
 
 import (
@@ -8,12 +7,13 @@ import (
         "fmt"
         "io"
         "io/ioutil"
-        "math/rand"
         "net"
+        "net/http"
         "net/url"
         "os"
         "path/filepath"
         "regexp"
+        "sort"
         "strconv"
         "strings"
         "time"
@@ -33,10 +33,6 @@ var (
         StyleBright  = "\033[1m"
 
         NOCOLOR bool // set from CLI
-
-        // proxyAddr if set makes the program route connections via the specified HTTP proxy.
-        // Expected format: "host:port"
-        proxyAddr string
 )
 
 // EndChunk is the terminating chunk marker for chunked encoding.
@@ -51,33 +47,62 @@ type Payload struct {
         Method   string
         Endpoint string
         Host     string
-        CL       int // if <0 then use len(body) in replacement
+        CL       int        // if <0 then use len(body) in replacement
+        CB       string     // cache-buster query parameter merged into Endpoint, "" for none
+        CLGadget string     // replaces the Content-Length line, may use __CL_ALT__
+        AltCL    int        // value of __CL_ALT__
+        Chunks   chunkStyle // chunk layer of the TECL and CLTE bodies
+
+        RequestLine string // replaces the request line, "" keeps the template's; a verbatim target is kept
 }
 
 func (p *Payload) String() string {
+        return strings.ReplaceAll(p.render(false), SplitMarker, "")
+}
+
+// render expands all placeholders but keeps split markers in place. If
+// splitHeaders is set a marker is added between the header block and body.
+func (p *Payload) render(splitHeaders bool) string {
         if p.Header == "" {
                 panic("No header data specified in Payload instance")
         }
         if p.Host == "" {
                 panic("No host specified in Payload instance")
         }
-        result := p.Header + "\r\n" + p.Body
+        header := p.Header
+        if p.RequestLine != "" {
+                end := strings.Index(header, "\r\n")
+                header = withBaseTarget(p.RequestLine, header[:end]) + header[end:]
+        }
+        result := header + "\r\n" + p.Body
+        if splitHeaders {
+                result = header + "\r\n" + SplitMarker + p.Body
+        }
+        result = strings.ReplaceAll(result, "__ENDPOINT__", addQueryParam(p.Endpoint, p.CB, "__RANDOM__"))
         result = replaceRandom(result)
+        if p.CLGadget != "" {
+                result = strings.Replace(result, "Content-Length: __REPLACE_CL__", p.CLGadget, 1)
+                result = strings.ReplaceAll(result, "__CL_ALT__", strconv.Itoa(p.AltCL))
+        }
         clVal := p.CL
         if clVal < 0 {
-                clVal = len(p.Body)
+                clVal = len(strings.ReplaceAll(p.Body, SplitMarker, ""))
         }
         result = strings.ReplaceAll(result, "__REPLACE_CL__", strconv.Itoa(clVal))
+        result = strings.ReplaceAll(result, "__METHOD_LOWER__", strings.ToLower(p.Method))
         result = strings.ReplaceAll(result, "__METHOD__", p.Method)
-        result = strings.ReplaceAll(result, "__ENDPOINT__", p.Endpoint)
         result = strings.ReplaceAll(result, "__HOST__", p.Host)
         return result
 }
 
+// randFloat is the source of __RANDOM__ values. Tests replace it to make
+// rendered payloads deterministic.
+var randFloat = func() float64 { return rng.Float64() }
+
 func replaceRandom(text string) string {
         re := regexp.MustCompile(`__RANDOM__`)
         return re.ReplaceAllStringFunc(text, func(match string) string {
-                f := rand.Float64()
+                f := randFloat()
                 parts := strings.Split(fmt.Sprintf("%f", f), ".")
                 if len(parts) > 1 {
                         return parts[1]
@@ -93,85 +118,92 @@ func Chunked(data string) string {
 // ------------------------------
 // renderTemplate and mutations initialization
 
+// renderTemplate places gadget in the base request from tmplOpts. An empty
+// gadget yields a plain, well-formed request.
 func renderTemplate(gadget string) *Payload {
-        RN := "\r\n"
         p := &Payload{
-                Header: "__METHOD__ __ENDPOINT__?cb=__RANDOM__ HTTP/1.1" + RN +
-                        gadget + RN +
-                        "Host: __HOST__" + RN +
-                        "User-Agent: Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/78.0.3904.87 Safari/537.36" + RN +
-                        "Content-type: application/x-www-form-urlencoded; charset=UTF-8" + RN +
-                        "Content-Length: __REPLACE_CL__" + RN,
+                Header:   tmplOpts.header(gadget),
                 Body:     "",
                 Method:   "GET",
                 Endpoint: "/",
                 Host:     "",
                 CL:       -1,
+                CB:       tmplOpts.queryCacheBuster(),
         }
         return p
 }
 
-func initMutations() map[string]*Payload {
-        mutations := make(map[string]*Payload)
-        mutations["nameprefix1"] = renderTemplate(" Transfer-Encoding: chunked")
-        mutations["tabprefix1"] = renderTemplate("Transfer-Encoding:\tchunked")
-        mutations["tabprefix2"] = renderTemplate("Transfer-Encoding\t:\tchunked")
-        mutations["spacejoin1"] = renderTemplate("Transfer Encoding: chunked")
-        mutations["underjoin1"] = renderTemplate("Transfer_Encoding: chunked")
-        mutations["smashed"] = renderTemplate("Transfer Encoding:chunked")
-        mutations["space1"] = renderTemplate("Transfer-Encoding : chunked")
-        mutations["valueprefix1"] = renderTemplate("Transfer-Encoding:  chunked")
-        mutations["vertprefix1"] = renderTemplate("Transfer-Encoding:\u000Bchunked")
-        mutations["commaCow"] = renderTemplate("Transfer-Encoding: chunked, cow")
-        mutations["cowComma"] = renderTemplate("Transfer-Encoding: cow, chunked")
-        mutations["contentEnc"] = renderTemplate("Content-Encoding: chunked")
-        mutations["linewrapped1"] = renderTemplate("Transfer-Encoding:\n chunked")
-        mutations["quoted"] = renderTemplate("Transfer-Encoding: \"chunked\"")
-        mutations["aposed"] = renderTemplate("Transfer-Encoding: 'chunked'")
-        mutations["lazygrep"] = renderTemplate("Transfer-Encoding: chunk")
-        mutations["sarcasm"] = renderTemplate("TrAnSFer-EnCODinG: cHuNkeD")
-        mutations["yelling"] = renderTemplate("TRANSFER-ENCODING: CHUNKED")
-        mutations["0dsuffix"] = renderTemplate("Transfer-Encoding: chunked\r")
-        mutations["tabsuffix"] = renderTemplate("Transfer-Encoding: chunked\t")
-        mutations["revdualchunk"] = renderTemplate("Transfer-Encoding: cow\r\nTransfer-Encoding: chunked")
-        mutations["0dspam"] = renderTemplate("Transfer\r-Encoding: chunked")
-        mutations["nested"] = renderTemplate("Transfer-Encoding: cow chunked bar")
-        mutations["spaceFF"] = renderTemplate("Transfer-Encoding:\xFFchunked")
-        mutations["accentCH"] = renderTemplate("Transfer-Encoding: ch\x96nked")
-        mutations["accentTE"] = renderTemplate("Transf\x82r-Encoding: chunked")
-        mutations["x-rout"] = renderTemplate("X:X\rTransfer-Encoding: chunked")
-        mutations["x-nout"] = renderTemplate("X:X\nTransfer-Encoding: chunked")
+// teGadgets returns the Transfer-Encoding header gadgets keyed by mutation
+// name.
+func teGadgets() map[string]string {
+        gadgets := make(map[string]string)
+        gadgets["nameprefix1"] = " Transfer-Encoding: chunked"
+        gadgets["tabprefix1"] = "Transfer-Encoding:\tchunked"
+        gadgets["tabprefix2"] = "Transfer-Encoding\t:\tchunked"
+        gadgets["spacejoin1"] = "Transfer Encoding: chunked"
+        gadgets["underjoin1"] = "Transfer_Encoding: chunked"
+        gadgets["smashed"] = "Transfer Encoding:chunked"
+        gadgets["space1"] = "Transfer-Encoding : chunked"
+        gadgets["valueprefix1"] = "Transfer-Encoding:  chunked"
+        gadgets["vertprefix1"] = "Transfer-Encoding:\u000Bchunked"
+        gadgets["commaCow"] = "Transfer-Encoding: chunked, cow"
+        gadgets["cowComma"] = "Transfer-Encoding: cow, chunked"
+        gadgets["contentEnc"] = "Content-Encoding: chunked"
+        gadgets["linewrapped1"] = "Transfer-Encoding:\n chunked"
+        gadgets["quoted"] = "Transfer-Encoding: \"chunked\""
+        gadgets["aposed"] = "Transfer-Encoding: 'chunked'"
+        gadgets["lazygrep"] = "Transfer-Encoding: chunk"
+        gadgets["sarcasm"] = "TrAnSFer-EnCODinG: cHuNkeD"
+        gadgets["yelling"] = "TRANSFER-ENCODING: CHUNKED"
+        gadgets["0dsuffix"] = "Transfer-Encoding: chunked\r"
+        gadgets["tabsuffix"] = "Transfer-Encoding: chunked\t"
+        gadgets["revdualchunk"] = "Transfer-Encoding: cow\r\nTransfer-Encoding: chunked"
+        gadgets["0dspam"] = "Transfer\r-Encoding: chunked"
+        gadgets["nested"] = "Transfer-Encoding: cow chunked bar"
+        gadgets["spaceFF"] = "Transfer-Encoding:\xFFchunked"
+        gadgets["accentCH"] = "Transfer-Encoding: ch\x96nked"
+        gadgets["accentTE"] = "Transf\x82r-Encoding: chunked"
+        gadgets["x-rout"] = "X:X\rTransfer-Encoding: chunked"
+        gadgets["x-nout"] = "X:X\nTransfer-Encoding: chunked"
         for i := 0x1; i < 0x20; i++ {
                 keyA := fmt.Sprintf("%02x-%02x-XX-XX", i, i)
-                mutations[keyA] = renderTemplate(fmt.Sprintf("%cTransfer-Encoding%c: chunked", i, i))
+                gadgets[keyA] = fmt.Sprintf("%cTransfer-Encoding%c: chunked", i, i)
                 keyB := fmt.Sprintf("%02x-XX-%02x-XX", i, i)
-                mutations[keyB] = renderTemplate(fmt.Sprintf("%cTransfer-Encoding:%cchunked", i, i))
+                gadgets[keyB] = fmt.Sprintf("%cTransfer-Encoding:%cchunked", i, i)
                 keyC := fmt.Sprintf("%02x-XX-XX-%02x", i, i)
-                mutations[keyC] = renderTemplate(fmt.Sprintf("%cTransfer-Encoding: chunked%c", i, i))
+                gadgets[keyC] = fmt.Sprintf("%cTransfer-Encoding: chunked%c", i, i)
                 keyD := fmt.Sprintf("XX-%02x-%02x-XX", i, i)
-                mutations[keyD] = renderTemplate(fmt.Sprintf("Transfer-Encoding%c:%cchunked", i, i))
+                gadgets[keyD] = fmt.Sprintf("Transfer-Encoding%c:%cchunked", i, i)
                 keyE := fmt.Sprintf("XX-%02x-XX-%02x", i, i)
-                mutations[keyE] = renderTemplate(fmt.Sprintf("Transfer-Encoding%c: chunked%c", i, i))
+                gadgets[keyE] = fmt.Sprintf("Transfer-Encoding%c: chunked%c", i, i)
                 keyF := fmt.Sprintf("XX-XX-%02x-%02x", i, i)
-                mutations[keyF] = renderTemplate(fmt.Sprintf("Transfer-Encoding:%cchunked%c", i, i))
+                gadgets[keyF] = fmt.Sprintf("Transfer-Encoding:%cchunked%c", i, i)
                 keyMid := fmt.Sprintf("midspace-%02x", i)
-                mutations[keyMid] = renderTemplate(fmt.Sprintf("Transfer-Encoding:%cchunked", i))
+                gadgets[keyMid] = fmt.Sprintf("Transfer-Encoding:%cchunked", i)
                 keyPost := fmt.Sprintf("postspace-%02x", i)
-                mutations[keyPost] = renderTemplate(fmt.Sprintf("Transfer-Encoding%c: chunked", i))
+                gadgets[keyPost] = fmt.Sprintf("Transfer-Encoding%c: chunked", i)
                 keyPre := fmt.Sprintf("prespace-%02x", i)
-                mutations[keyPre] = renderTemplate(fmt.Sprintf("%cTransfer-Encoding: chunked", i))
+                gadgets[keyPre] = fmt.Sprintf("%cTransfer-Encoding: chunked", i)
                 keyEnd := fmt.Sprintf("endspace-%02x", i)
-                mutations[keyEnd] = renderTemplate(fmt.Sprintf("Transfer-Encoding: chunked%c", i))
+                gadgets[keyEnd] = fmt.Sprintf("Transfer-Encoding: chunked%c", i)
         }
         for i := 0x7F; i < 0x100; i++ {
                 keyMid := fmt.Sprintf("midspace-%02x", i)
-                mutations[keyMid] = renderTemplate(fmt.Sprintf("Transfer-Encoding:%cchunked", i))
+                gadgets[keyMid] = fmt.Sprintf("Transfer-Encoding:%cchunked", i)
                 keyPost := fmt.Sprintf("postspace-%02x", i)
-                mutations[keyPost] = renderTemplate(fmt.Sprintf("Transfer-Encoding%c: chunked", i))
+                gadgets[keyPost] = fmt.Sprintf("Transfer-Encoding%c: chunked", i)
                 keyPre := fmt.Sprintf("prespace-%02x", i)
-                mutations[keyPre] = renderTemplate(fmt.Sprintf("%cTransfer-Encoding: chunked", i))
+                gadgets[keyPre] = fmt.Sprintf("%cTransfer-Encoding: chunked", i)
                 keyEnd := fmt.Sprintf("endspace-%02x", i)
-                mutations[keyEnd] = renderTemplate(fmt.Sprintf("Transfer-Encoding: chunked%c", i))
+                gadgets[keyEnd] = fmt.Sprintf("Transfer-Encoding: chunked%c", i)
+        }
+        return gadgets
+}
+
+func initMutations() map[string]*Payload {
+        mutations := make(map[string]*Payload)
+        for name, gadget := range mutationGadgets() {
+                mutations[name] = renderTemplate(gadget)
         }
         return mutations
 }
@@ -180,74 +212,38 @@ func initMutations() map[string]*Payload {
 // EasySSL equivalent functions
 
 func easySSLConnect(host string, port int, timeout time.Duration, useTLS bool) (net.Conn, error) {
-        targetAddr := fmt.Sprintf("%s:%d", host, port)
+        dialHost, dialPort := dialAddress(host, port)
+        return easySSLConnectTo(host, dialHost, dialPort, timeout, useTLS)
+}
+
+// easySSLConnectTo connects to dialHost:dialPort but performs the TLS
+// handshake for host, so SNI is unaffected by where the connection goes.
+func easySSLConnectTo(host, dialHost string, dialPort int, timeout time.Duration, useTLS bool) (net.Conn, error) {
         var conn net.Conn
         var err error
 
-        if proxyAddr != "" {
-                conn, err = net.DialTimeout("tcp", proxyAddr, timeout)
-                if err != nil {
-                        return nil, err
-                }
-                if useTLS {
-                        connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", targetAddr, targetAddr)
-                        conn.SetWriteDeadline(time.Now().Add(timeout))
-                        _, err = conn.Write([]byte(connectReq))
-                        if err != nil {
-                                conn.Close()
-                                return nil, err
-                        }
-                        conn.SetReadDeadline(time.Now().Add(timeout))
-                        respReader := bufio.NewReader(conn)
-                        resp, err := respReader.ReadString('\n')
-                        if err != nil {
-                                conn.Close()
-                                return nil, err
-                        }
-                        if !strings.Contains(resp, "200") {
-                                conn.Close()
-                                return nil, fmt.Errorf("proxy CONNECT failed: %s", resp)
-                        }
-                        for {
-                                line, err := respReader.ReadString('\n')
-                                if err != nil {
-                                        break
-                                }
-                                if line == "\r\n" {
-                                        break
-                                }
-                        }
-                }
-                conn.SetDeadline(time.Now().Add(timeout))
+        if upstreamProxy != nil && upstreamProxy.isSOCKS() {
+                conn, err = dialSOCKS5(upstreamProxy, dialHost, dialPort, timeout)
+        } else if upstreamProxy != nil {
+                conn, err = dialHTTPProxy(upstreamProxy, dialHost, dialPort, timeout)
         } else {
-                addr := fmt.Sprintf("%s:%d", host, port)
-                conn, err = net.DialTimeout("tcp", addr, timeout)
-                if err != nil {
-                        return nil, err
-                }
-                conn.SetDeadline(time.Now().Add(timeout))
+                conn, err = dialTCP(dialHost, dialPort, timeout)
+        }
+        if err != nil {
+                return nil, err
         }
+        conn.SetDeadline(time.Now().Add(timeout))
 
-        if useTLS && proxyAddr == "" {
-                config := &tls.Config{
-                        InsecureSkipVerify: true,
-                }
-                tlsConn := tls.Client(conn, config)
+        if useTLS {
+                tlsConn := tls.Client(conn, tlsOpts.config(host))
                 err = tlsConn.Handshake()
                 if err != nil {
+                        conn.Close()
                         return nil, err
                 }
-                tlsConn.SetDeadline(time.Now().Add(timeout))
-                return tlsConn, nil
-        } else if useTLS && proxyAddr != "" {
-                config := &tls.Config{
-                        InsecureSkipVerify: true,
-                        ServerName:         host,
-                }
-                tlsConn := tls.Client(conn, config)
-                err = tlsConn.Handshake()
-                if err != nil {
-                        return nil, err
+                if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+                        tlsConn.Close()
+                        return nil, fmt.Errorf("server negotiated h2, payloads are raw HTTP/1.1")
                 }
                 tlsConn.SetDeadline(time.Now().Add(timeout))
                 return tlsConn, nil
@@ -259,48 +255,73 @@ func easySSLConnect(host string, port int, timeout time.Duration, useTLS bool) (
 // Desyncr type and methods
 
 type Desyncr struct {
-        host      string
-        port      int
-        method    string
-        endpoint  string
-        vhost     string
-        url       string
-        timeout   time.Duration
-        sslFlag   bool
-        logh      io.Writer
-        quiet     bool
-        exitEarly bool
-        attempts  int
-        cookies   []string
-        mutations map[string]*Payload
+        host       string
+        port       int
+        ip         string // address connections end up at, for display
+        connectIP  string // if set, every connection goes to this address
+        method     string
+        endpoint   string
+        vhost      string
+        url        string
+        timeout    time.Duration
+        sslFlag    bool
+        logh       io.Writer
+        quiet      bool
+        exitEarly  bool
+        attempts   int
+        jar        http.CookieJar
+        mutations  map[string]*Payload
+        segments   SegmentPlan
+        keepAlive  int  // if >0 probe connection reuse with this many requests
+        pipelined  bool // pipeline the keep-alive probe requests
+        findings   []string
+        families   map[string]bool // mutation families to run, nil for all
+        combos     *comboRun       // stacked mutations to run instead of the families
+        fuzzCount  int             // if >0 evaluate this many generated gadgets instead
+        discovered []mutationSpec  // fuzzed gadgets with a differential response
+}
+
+// family reports whether the mutation family is enabled for this scan.
+func (d *Desyncr) family(name string) bool {
+        return d.families == nil || d.families[name]
+}
+
+// connect opens a connection to the target, pinned to connectIP if set.
+func (d *Desyncr) connect(timeout time.Duration) (net.Conn, error) {
+        if d.connectIP == "" {
+                return easySSLConnect(d.host, d.port, timeout, d.sslFlag)
+        }
+        _, dialPort := dialAddress(d.host, d.port)
+        return easySSLConnectTo(d.host, d.connectIP, dialPort, timeout, d.sslFlag)
+}
+
+// hostHeader is the Host header value used for the target: the vhost if
+// given, otherwise the target host formatted for the header.
+func (d *Desyncr) hostHeader() string {
+        if d.vhost != "" {
+                return d.vhost
+        }
+        return formatHostHeader(d.host, d.port, d.sslFlag)
 }
 
 func (d *Desyncr) test(p *Payload) (int, string, *Payload) {
-        conn, err := easySSLConnect(d.host, d.port, d.timeout, d.sslFlag)
+        conn, err := d.connect(d.timeout)
         if err != nil {
                 return -1, "", p
         }
         defer conn.Close()
 
-        payloadStr := p.String()
-        _, err = conn.Write([]byte(payloadStr))
+        err = writeSegments(conn, p.Segments(d.segments), d.segments, d.timeout)
         if err != nil {
                 return -1, "", p
         }
 
-        startTime := time.Now()
+        startTime := clock.Now()
         conn.SetReadDeadline(time.Now().Add(d.timeout))
         buf := make([]byte, 4096)
         n, err := conn.Read(buf)
-        endTime := time.Now()
-        if err != nil {
-                if ne, ok := err.(net.Error); ok && ne.Timeout() {
-                        if endTime.Sub(startTime) < d.timeout-time.Second {
-                                return 2, "", p
-                        }
-                        return 1, "", p
-                }
-                return -1, "", p
+        if code := classifyRead(err, clock.Now().Sub(startTime), d.timeout); code != readOK {
+                return code, "", p
         }
 
         var resFiltered bytes.Buffer
@@ -315,91 +336,85 @@ func (d *Desyncr) test(p *Payload) (int, string, *Payload) {
         return 0, resFiltered.String(), p
 }
 
+// getCookieTimeout bounds the cookie fetch, independently of the scan timeout.
+const getCookieTimeout = 2 * time.Second
+
+// getCookies seeds the jar and, unless disabled, requests the endpoint once
+// to collect the cookies the target sets. The request goes through the same
+// pinned connection path as the checks.
 func (d *Desyncr) getCookies() bool {
-        RN := "\r\n"
-        p := &Payload{
-                Host:     d.host,
-                Method:   "GET",
-                Endpoint: d.endpoint,
-                Header: "__METHOD__ __ENDPOINT__?cb=" + randomString(5) + " HTTP/1.1" + RN +
-                        "Host: __HOST__" + RN +
-                        "User-Agent: Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/78.0.3904.87 Safari/537.36" + RN +
-                        "Content-type: application/x-www-form-urlencoded; charset=UTF-8" + RN +
-                        "Content-Length: 0" + RN,
-                Body: "",
-                CL:   -1,
-        }
-        conn, err := easySSLConnect(d.host, d.port, 2*time.Second, d.sslFlag)
-        if err != nil {
-                printInfo("Error      : "+ColorCyan+"Unable to connect to host"+ColorMagenta, d.logh)
+        if err := d.seedCookies(); err != nil {
+                printInfo("Error      : "+ColorCyan+err.Error()+ColorMagenta, d.logh)
                 return false
         }
-        defer conn.Close()
-
-        _, err = conn.Write([]byte(p.String()))
-        if err != nil {
-                printInfo("Error      : "+ColorCyan+"Failed to send cookies request"+ColorMagenta, d.logh)
+        if cookieOpts.NoFetch {
+                printInfo(fmt.Sprintf("Cookies    : %s (Seeded, fetch skipped)", ColorCyan+fmt.Sprintf("%d", len(d.jar.Cookies(d.jarURL())))+ColorMagenta), d.logh)
+                return true
+        }
+        ex := d.sendSequenceWithin([]*Payload{d.plainPayload()}, false, getCookieTimeout)[0]
+        if !ex.Connected {
+                printInfo("Error      : "+ColorCyan+"Unable to connect to host"+ColorMagenta+" ("+ex.Err.Error()+")", d.logh)
                 return false
         }
-
-        time.Sleep(500 * time.Millisecond)
-        conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-        response, err := ioutil.ReadAll(conn)
-        if err != nil {
+        if ex.Status == 0 {
+                // A stall, a close or a non-HTTP reply leaves only the seeded
+                // cookies; the scan still goes ahead.
+                printInfo("Error      : "+ColorCyan+"No response to cookies request"+ColorMagenta+fmt.Sprintf(" (%v)", ex.Err), d.logh)
                 return true
         }
-
-        lines := strings.Split(string(response), RN)
-        for _, line := range lines {
-                if len(line) > 11 && strings.ToLower(strings.ReplaceAll(line[0:11], " ", "")) == "set-cookie:" {
-                        cookie := strings.Split(strings.ToLower(strings.Replace(line, "set-cookie:", "", 1)), ";")[0] + ";"
-                        d.cookies = append(d.cookies, cookie)
-                }
-        }
-        printInfo(fmt.Sprintf("Cookies    : %s (Appending to the attack)", ColorCyan+fmt.Sprintf("%d", len(d.cookies))+ColorMagenta), d.logh)
+        d.storeResponseCookies(ex.Response)
+        printInfo(fmt.Sprintf("Cookies    : %s (Appending to the attack)", ColorCyan+fmt.Sprintf("%d", len(d.jar.Cookies(d.jarURL())))+ColorMagenta), d.logh)
         return true
 }
 
-func (d *Desyncr) checkTECL(payload *Payload, ptype int) (int, string, *Payload) {
-        tePayload := *payload
-        if d.vhost == "" {
-                tePayload.Host = d.host
-        } else {
-                tePayload.Host = d.vhost
-        }
-        tePayload.Method = d.method
-        tePayload.Endpoint = d.endpoint
-        if len(d.cookies) > 0 {
-                tePayload.Header += "Cookie: " + strings.Join(d.cookies, "") + "\r\n"
+// attackPayload copies payload and aims it at the target, with cookies.
+func (d *Desyncr) attackPayload(payload *Payload) *Payload {
+        p := *payload
+        p.Host = d.hostHeader()
+        p.Method = d.method
+        p.Endpoint = d.endpoint
+        if cookie := d.cookieHeader(); cookie != "" {
+                p.Header += "Cookie: " + cookie + "\r\n"
         }
+        return &p
+}
+
+// teclPayload ends the chunked body one byte before Content-Length does
+// (6 and 5 for canonical chunks), so a CL back-end waits for ptype 0.
+func (d *Desyncr) teclPayload(payload *Payload, ptype int) *Payload {
+        tePayload := d.attackPayload(payload)
+        last := tePayload.Chunks.last()
         if ptype == 0 {
-                tePayload.CL = 6
+                tePayload.CL = len(last) + 1
         } else {
-                tePayload.CL = 5
+                tePayload.CL = len(last)
         }
-        tePayload.Body = EndChunk + "X"
-        return d.test(&tePayload)
+        tePayload.Body = last + "X"
+        tePayload.AltCL = tePayload.CL
+        return tePayload
 }
 
-func (d *Desyncr) checkCLTE(payload *Payload, ptype int) (int, string, *Payload) {
-        tePayload := *payload
-        if d.vhost == "" {
-                tePayload.Host = d.host
-        } else {
-                tePayload.Host = d.vhost
-        }
-        tePayload.Method = d.method
-        tePayload.Endpoint = d.endpoint
-        if len(d.cookies) > 0 {
-                tePayload.Header += "Cookie: " + strings.Join(d.cookies, "") + "\r\n"
-        }
+func (d *Desyncr) checkTECL(payload *Payload, ptype int) (int, string, *Payload) {
+        return d.test(d.teclPayload(payload, ptype))
+}
+
+// cltePayload cuts Content-Length one byte into the first chunk's data for
+// ptype 0 (4 and 11 for canonical chunks), so a TE back-end waits for it.
+func (d *Desyncr) cltePayload(payload *Payload, ptype int) *Payload {
+        tePayload := d.attackPayload(payload)
+        c := tePayload.Chunks
+        tePayload.Body = c.chunk(c.data()) + c.last()
         if ptype == 0 {
-                tePayload.CL = 4
+                tePayload.CL = len(c.dataSizeLine(c.data())) + 1
         } else {
-                tePayload.CL = 11
+                tePayload.CL = len(tePayload.Body)
         }
-        tePayload.Body = Chunked("Z") + EndChunk
-        return d.test(&tePayload)
+        tePayload.AltCL = tePayload.CL
+        return tePayload
+}
+
+func (d *Desyncr) checkCLTE(payload *Payload, ptype int) (int, string, *Payload) {
+        return d.test(d.cltePayload(payload, ptype))
 }
 
 // extractStatusCode parses the first line of an HTTP response and returns the status code.
@@ -415,29 +430,35 @@ func extractStatusCode(response string) string {
         return "N/A"
 }
 
-// createExecTest uses prettyPrint (like the original Go program) to print the checking line,
-// then updates that line when status codes are received.
-func (d *Desyncr) createExecTest(name string, tePayload *Payload) bool {
-        // Use the original prettyPrint function to display the message.
-        prettyPrint := func(label, msg string) {
-                fmt.Printf("\r%s\r", strings.Repeat(" ", 100))
-                // Build the output with payload name in cyan wrapped within magenta brackets.
-                output := StyleBright + ColorMagenta + fmt.Sprintf("[%s]%s: %s", ColorCyan+label+ColorMagenta, strings.Repeat(" ", 13-len(label)), msg) + ColorReset
-                fmt.Print(cf(output))
-                if d.logh != nil {
-                        fmt.Fprintln(d.logh, stripANSI(output))
-                }
+// prettyPrint (like the original Go program) rewrites the current status
+// line for a mutation.
+func (d *Desyncr) prettyPrint(label, msg string) {
+        fmt.Printf("\r%s\r", strings.Repeat(" ", 100))
+        // Build the output with payload name in cyan wrapped within magenta brackets.
+        pad := 13 - len(label)
+        if pad < 0 {
+                pad = 0
+        }
+        output := StyleBright + ColorMagenta + fmt.Sprintf("[%s]%s: %s", ColorCyan+label+ColorMagenta, strings.Repeat(" ", pad), msg) + ColorReset
+        fmt.Print(cf(output))
+        if d.logh != nil {
+                fmt.Fprintln(d.logh, stripANSI(output))
         }
+}
+
+// createExecTest uses prettyPrint to print the checking line, then updates
+// that line when status codes are received.
+func (d *Desyncr) createExecTest(name string, tePayload *Payload) bool {
 
         // Start with an initial checking line.
-        prettyPrint(name, "Checking...")
+        d.prettyPrint(name, "Checking...")
         // Pause briefly
         time.Sleep(200 * time.Millisecond)
 
         // TECL test.
-        startTime := time.Now()
+        startTime := clock.Now()
         teclCode, teclRes, _ := d.checkTECL(tePayload, 0)
-        teclTime := time.Since(startTime).Seconds()
+        teclTime := clock.Now().Sub(startTime).Seconds()
         var statusTecl string
         if teclCode == 0 {
                 statusTecl = extractStatusCode(teclRes)
@@ -445,12 +466,12 @@ func (d *Desyncr) createExecTest(name string, tePayload *Payload) bool {
                 statusTecl = "ERR"
         }
         // Update line after TECL test.
-        prettyPrint(name, fmt.Sprintf("TECL: %s (%.2fs)", statusTecl, teclTime))
+        d.prettyPrint(name, fmt.Sprintf("TECL: %s (%.2fs)", statusTecl, teclTime))
 
         // CLTE test.
-        startTime = time.Now()
+        startTime = clock.Now()
         clteCode, clteRes, _ := d.checkCLTE(tePayload, 0)
-        clteTime := time.Since(startTime).Seconds()
+        clteTime := clock.Now().Sub(startTime).Seconds()
         var statusClte string
         if clteCode == 0 {
                 statusClte = extractStatusCode(clteRes)
@@ -466,7 +487,7 @@ func (d *Desyncr) createExecTest(name string, tePayload *Payload) bool {
         } else if teclCode == 2 || clteCode == 2 {
                 finalMsg += " - DISCONNECTED"
         }
-        prettyPrint(name, finalMsg)
+        d.prettyPrint(name, finalMsg)
         fmt.Println()
 
         // Edge-case retry logic.
@@ -477,14 +498,16 @@ func (d *Desyncr) createExecTest(name string, tePayload *Payload) bool {
                         if d.attempts < 3 {
                                 return d.createExecTest(name, tePayload)
                         } else {
-                                prettyPrint(name, fmt.Sprintf("Potential CLTE Issue Found - %s @ http://%s%s", d.method, d.host, d.endpoint))
+                                d.prettyPrint(name, fmt.Sprintf("Potential CLTE Issue Found - %s @ http://%s%s (%s)", d.method, formatHostHeader(d.host, d.port, d.sslFlag), d.endpoint, d.ip))
                                 writePayload(d.host, tePayload, "CLTE", name, d.url, d.sslFlag)
+                                d.findings = append(d.findings, "CLTE "+name)
                                 d.attempts = 0
                                 fmt.Println()
+                                d.reportMutation(name)
                                 return true
                         }
                 } else {
-                        prettyPrint(name, ColorYellow+"CLTE TIMEOUT ON BOTH LENGTH 4 AND 11"+ColorReset)
+                        d.prettyPrint(name, ColorYellow+fmt.Sprintf("CLTE TIMEOUT ON BOTH LENGTH %d AND %d", d.cltePayload(tePayload, 0).CL, d.cltePayload(tePayload, 1).CL)+ColorReset)
                         fmt.Println()
                 }
         } else if teclCode == 1 {
@@ -494,18 +517,20 @@ func (d *Desyncr) createExecTest(name string, tePayload *Payload) bool {
                         if d.attempts < 3 {
                                 return d.createExecTest(name, tePayload)
                         } else {
-                                prettyPrint(name, fmt.Sprintf("Potential TECL Issue Found - %s @ http://%s%s", d.method, d.host, d.endpoint))
+                                d.prettyPrint(name, fmt.Sprintf("Potential TECL Issue Found - %s @ http://%s%s (%s)", d.method, formatHostHeader(d.host, d.port, d.sslFlag), d.endpoint, d.ip))
                                 writePayload(d.host, tePayload, "TECL", name, d.url, d.sslFlag)
+                                d.findings = append(d.findings, "TECL "+name)
                                 d.attempts = 0
                                 fmt.Println()
+                                d.reportMutation(name)
                                 return true
                         }
                 } else {
-                        prettyPrint(name, ColorYellow+"TECL TIMEOUT ON BOTH LENGTH 6 AND 5"+ColorReset)
+                        d.prettyPrint(name, ColorYellow+fmt.Sprintf("TECL TIMEOUT ON BOTH LENGTH %d AND %d", d.teclPayload(tePayload, 0).CL, d.teclPayload(tePayload, 1).CL)+ColorReset)
                         fmt.Println()
                 }
         } else if teclCode == -1 || clteCode == -1 {
-                prettyPrint(name, ColorYellow+"SOCKET ERROR"+ColorReset)
+                d.prettyPrint(name, ColorYellow+"SOCKET ERROR"+ColorReset)
                 fmt.Println()
         }
         d.attempts = 0
@@ -513,7 +538,7 @@ func (d *Desyncr) createExecTest(name string, tePayload *Payload) bool {
 }
 
 func writePayload(smhost string, payload *Payload, ptype, name, urlStr string, sslFlag bool) {
-        furl := strings.ReplaceAll(smhost, ".", "_")
+        furl := strings.NewReplacer(".", "_", ":", "_").Replace(smhost)
         if sslFlag {
                 furl = "https_" + furl
         } else {
@@ -534,12 +559,51 @@ func (d *Desyncr) run() {
         if !d.getCookies() {
                 return
         }
-        d.mutations = initMutations()
-        for mutName, mutPayload := range d.mutations {
-                mutPayload.Host = d.host
-                if d.createExecTest(mutName, mutPayload) && d.exitEarly {
-                        break
+        if d.keepAlive > 0 {
+                d.keepAliveProbe(d.keepAlive, d.pipelined)
+        }
+        if d.fuzzCount > 0 {
+                d.discovered = d.evaluateGadgets(fuzzCandidates(d.fuzzCount))
+                if d.quiet {
+                        fmt.Printf("\r%s\r", strings.Repeat(" ", 100))
+                }
+                return
+        }
+        if d.combos != nil {
+                d.runCombos(d.combos)
+                if d.quiet {
+                        fmt.Printf("\r%s\r", strings.Repeat(" ", 100))
                 }
+                return
+        }
+        found := false
+        if d.family("te") {
+                d.mutations = initMutations()
+                // A fixed order keeps payloads reproducible under --seed.
+                names := make([]string, 0, len(d.mutations))
+                for name := range d.mutations {
+                        names = append(names, name)
+                }
+                sort.Strings(names)
+                for _, mutName := range names {
+                        mutPayload := d.mutations[mutName]
+                        mutPayload.Host = d.hostHeader()
+                        if d.createExecTest(mutName, mutPayload) {
+                                found = true
+                                if d.exitEarly {
+                                        break
+                                }
+                        }
+                }
+        }
+        if d.family("cl") && !(found && d.exitEarly) {
+                found = d.runCLCL() || found
+        }
+        if d.family("chunk") && !(found && d.exitEarly) {
+                found = d.runChunk() || found
+        }
+        if d.family("line") && !(found && d.exitEarly) {
+                d.runLine()
         }
         if d.quiet {
                 fmt.Printf("\r%s\r", strings.Repeat(" ", 100))
@@ -553,7 +617,7 @@ func randomString(n int) string {
         const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
         b := make([]byte, n)
         for i := range b {
-                b[i] = letters[rand.Intn(len(letters))]
+                b[i] = letters[rng.Intn(len(letters))]
         }
         return string(b)
 }
@@ -584,6 +648,16 @@ func processURI(uriStr string) (string, int, string, bool) {
                 printInfo("Error malformed URL not supported: "+uriStr, nil)
                 os.Exit(1)
         }
+        host, port, endpoint, sslFlag, ok := splitURL(u)
+        if !ok {
+                printInfo("Error malformed URL not supported: "+uriStr, nil)
+                os.Exit(1)
+        }
+        return host, port, endpoint, sslFlag
+}
+
+// splitURL breaks an http or https URL into the parts a Desyncr needs.
+func splitURL(u *url.URL) (string, int, string, bool, bool) {
         var sslFlag bool
         var stdPort int
         if u.Scheme == "https" {
@@ -593,17 +667,22 @@ func processURI(uriStr string) (string, int, string, bool) {
                 sslFlag = false
                 stdPort = 80
         } else {
-                printInfo("Error malformed URL not supported: "+uriStr, nil)
-                os.Exit(1)
+                return "", 0, "", false, false
         }
         port := stdPort
         if u.Port() != "" {
                 fmt.Sscanf(u.Port(), "%d", &port)
         }
-        return u.Hostname(), port, u.Path, sslFlag
+        endpoint := u.EscapedPath()
+        if endpoint == "" {
+                endpoint = "/"
+        }
+        if u.RawQuery != "" {
+                endpoint += "?" + u.RawQuery
+        }
+        return u.Hostname(), port, endpoint, sslFlag, true
 }
 
-
 func banner(version string) {
     fmt.Println(cf(ColorCyan))
     fmt.Println(cf("                                          ______   ______  "))
@@ -626,8 +705,6 @@ func banner(version string) {
 // ------------------------------
 // Main
 func main() {
-        rand.Seed(time.Now().UnixNano())
-
         // Command-line flag parsing.
         urlArg := ""
         vhost := ""
@@ -637,8 +714,51 @@ func main() {
         quiet := false
         timeoutSec := 5.0
         noColor := false
+        segments := defaultSegmentPlan()
+        keepAlive := 0
+        pipelined := false
+        intercept := false
+        allIPs := false
+        requestFile := ""
+        var targetInputs []struct{ kind, path string }
+        followRedirects := 0
+        retarget := false
+        echoAddr := "127.0.0.1:0"
+        simulate := ""
+        seed := int64(0)
+        mutationFile := ""
+        fuzzCount := 0
+        fuzzOut := ""
+        var families map[string]bool
+        var combineFamilies []string
+        combineBudget := 200
+        var comboNames []string
+        seeded := false
 
         args := os.Args[1:]
+        if len(args) > 0 && args[0] == "list-mutations" {
+                filter := ""
+                for i := 1; i < len(args); i++ {
+                        if args[i] != "--mutations" {
+                                filter = args[i]
+                                continue
+                        }
+                        if i+1 >= len(args) {
+                                printInfo("Error: --mutations needs a file", nil)
+                                os.Exit(1)
+                        }
+                        i++
+                        if _, err := useMutationFile(args[i]); err != nil {
+                                printInfo("Error: mutations: "+err.Error(), nil)
+                                os.Exit(1)
+                        }
+                }
+                if listMutations(os.Stdout, filter) == 0 {
+                        printInfo("Error: no mutation matches "+filter, nil)
+                        os.Exit(1)
+                }
+                return
+        }
         for i := 0; i < len(args); i++ {
                 switch args[i] {
                 case "-u", "--url":
@@ -666,7 +786,201 @@ func main() {
                         noColor = true
                 case "-x":
                         i++
-                        proxyAddr = args[i]
+                        conf, err := parseProxy(args[i])
+                        if err != nil {
+                                printInfo("Error: "+err.Error(), nil)
+                                os.Exit(1)
+                        }
+                        upstreamProxy = conf
+                case "--segments":
+                        i++
+                        if err := parseSegmentMode(args[i], &segments); err != nil {
+                                printInfo("Error: "+err.Error(), nil)
+                                os.Exit(1)
+                        }
+                case "--segment-delay":
+                        i++
+                        ms, err := strconv.Atoi(args[i])
+                        if err != nil || ms < 0 {
+                                printInfo("Error: invalid --segment-delay: "+args[i], nil)
+                                os.Exit(1)
+                        }
+                        segments.Delay = time.Duration(ms) * time.Millisecond
+                case "--nagle":
+                        segments.NoDelay = false
+                case "--keepalive":
+                        i++
+                        n, err := strconv.Atoi(args[i])
+                        if err != nil || n < 0 {
+                                printInfo("Error: invalid --keepalive count: "+args[i], nil)
+                                os.Exit(1)
+                        }
+                        keepAlive = n
+                case "--pipeline":
+                        pipelined = true
+                case "--seed":
+                        i++
+                        n, err := strconv.ParseInt(args[i], 10, 64)
+                        if err != nil {
+                                printInfo("Error: invalid --seed value: "+args[i], nil)
+                                os.Exit(1)
+                        }
+                        seed, seeded = n, true
+                case "--families":
+                        i++
+                        families = make(map[string]bool)
+                        for _, f := range strings.Split(args[i], ",") {
+                                if !mutationFamilies[f] {
+                                        printInfo("Error: unknown mutation family: "+f, nil)
+                                        os.Exit(1)
+                                }
+                                families[f] = true
+                        }
+                case "--combine":
+                        i++
+                        combineFamilies = strings.Split(args[i], ",")
+                case "--combine-budget":
+                        i++
+                        n, err := strconv.Atoi(args[i])
+                        if err != nil || n < 0 {
+                                printInfo("Error: invalid --combine-budget: "+args[i], nil)
+                                os.Exit(1)
+                        }
+                        combineBudget = n
+                case "--combo":
+                        i++
+                        comboNames = append(comboNames, args[i])
+                case "--mutations":
+                        i++
+                        mutationFile = args[i]
+                case "--fuzz":
+                        i++
+                        n, err := strconv.Atoi(args[i])
+                        if err != nil || n < 1 {
+                                printInfo("Error: invalid --fuzz count: "+args[i], nil)
+                                os.Exit(1)
+                        }
+                        fuzzCount = n
+                case "--fuzz-out":
+                        i++
+                        fuzzOut = args[i]
+                case "--simulate":
+                        i++
+                        simulate = args[i]
+                case "--intercept":
+                        intercept = true
+                case "--echo-addr":
+                        i++
+                        echoAddr = args[i]
+                case "--sni":
+                        i++
+                        tlsOpts.SNI = args[i]
+                case "--tls-verify":
+                        tlsOpts.Verify = true
+                case "--ca-file":
+                        i++
+                        tlsOpts.CAFile = args[i]
+                        tlsOpts.Verify = true
+                case "--client-cert":
+                        i++
+                        tlsOpts.CertFile = args[i]
+                case "--client-key":
+                        i++
+                        tlsOpts.KeyFile = args[i]
+                case "--resolve", "--connect-to":
+                        flag := args[i]
+                        i++
+                        parse, overrides := parseResolve, &resolveOverrides
+                        if flag == "--connect-to" {
+                                parse, overrides = parseConnectTo, &connectToOverrides
+                        }
+                        o, err := parse(args[i])
+                        if err != nil {
+                                printInfo("Error: "+err.Error(), nil)
+                                os.Exit(1)
+                        }
+                        *overrides = append(*overrides, o)
+                case "-H", "--header":
+                        i++
+                        h, err := parseHeaderArg(args[i])
+                        if err != nil {
+                                printInfo("Error: "+err.Error(), nil)
+                                os.Exit(1)
+                        }
+                        tmplOpts.Headers = append(tmplOpts.Headers, h)
+                case "--basic":
+                        i++
+                        tmplOpts.Headers = append(tmplOpts.Headers, basicAuthHeader(args[i]))
+                case "--bearer":
+                        i++
+                        tmplOpts.Headers = append(tmplOpts.Headers, bearerAuthHeader(args[i]))
+                case "-A", "--user-agent":
+                        i++
+                        tmplOpts.UserAgent = args[i]
+                case "-r", "--request":
+                        i++
+                        requestFile = args[i]
+                case "--cookie":
+                        i++
+                        cookieOpts.Seed = args[i]
+                case "--cookie-file":
+                        i++
+                        cookieOpts.File = args[i]
+                case "--no-cookie-fetch":
+                        cookieOpts.NoFetch = true
+                case "--cb":
+                        i++
+                        switch args[i] {
+                        case "query", "header", "none":
+                                tmplOpts.CacheBuster = args[i]
+                        default:
+                                printInfo("Error: --cb must be query, header or none", nil)
+                                os.Exit(1)
+                        }
+                case "--cb-name":
+                        i++
+                        tmplOpts.CacheBusterName = args[i]
+                case "--targets", "--burp", "--har":
+                        kind := strings.TrimPrefix(args[i], "--")
+                        i++
+                        targetInputs = append(targetInputs, struct{ kind, path string }{kind, args[i]})
+                case "--follow":
+                        i++
+                        if n, err := strconv.Atoi(args[i]); err == nil {
+                                followRedirects = n
+                        }
+                case "--retarget":
+                        retarget = true
+                        if followRedirects == 0 {
+                                followRedirects = 5
+                        }
+                case "--all-ips":
+                        allIPs = true
+                case "-4", "-6":
+                        ipOnly = args[i][1:]
+                case "--ip-pref":
+                        i++
+                        if args[i] != "4" && args[i] != "6" {
+                                printInfo("Error: --ip-pref must be 4 or 6", nil)
+                                os.Exit(1)
+                        }
+                        ipPreference = args[i]
+                case "--alpn":
+                        i++
+                        tlsOpts.ALPN = strings.Split(args[i], ",")
+                case "--tls-min", "--tls-max":
+                        flag := args[i]
+                        i++
+                        ver, err := parseTLSVersion(args[i])
+                        if err != nil {
+                                printInfo("Error: "+err.Error(), nil)
+                                os.Exit(1)
+                        }
+                        if flag == "--tls-min" {
+                                tlsOpts.MinVersion = ver
+                        } else {
+                                tlsOpts.MaxVersion = ver
+                        }
                 }
         }
 
@@ -678,23 +992,132 @@ func main() {
         Version := "v1.0"
         banner(Version)
 
+        if simulate != "" {
+                if err := runSimulator(simulate); err != nil {
+                        printInfo("Error: "+err.Error(), nil)
+                        os.Exit(1)
+                }
+                return
+        }
+
+        if !seeded {
+                seed = time.Now().UnixNano()
+        }
+        seedRandom(seed)
+
+        if err := tlsOpts.load(); err != nil {
+                printInfo("Error: TLS configuration: "+err.Error(), nil)
+                os.Exit(1)
+        }
+        if mutationFile != "" {
+                n, err := useMutationFile(mutationFile)
+                if err != nil {
+                        printInfo("Error: mutations: "+err.Error(), nil)
+                        os.Exit(1)
+                }
+                printInfo(fmt.Sprintf("Mutations  : %s%d%s loaded from %s", ColorCyan, n, ColorMagenta, mutationFile), nil)
+        }
+        var combos *comboRun
+        if len(combineFamilies) > 0 {
+                comb, err := newCombinator(combineFamilies)
+                if err != nil {
+                        printInfo("Error: "+err.Error(), nil)
+                        os.Exit(1)
+                }
+                combos = comb.sample(combineBudget)
+                printInfo(fmt.Sprintf("Combining  : %s%d%s of %d stacked mutations", ColorCyan, combos.size(), ColorMagenta, comb.size()), nil)
+        }
+        for _, name := range comboNames {
+                c, err := parseCombo(name)
+                if err != nil {
+                        printInfo("Error: "+err.Error(), nil)
+                        os.Exit(1)
+                }
+                if combos == nil {
+                        combos = &comboRun{}
+                }
+                combos.named = append(combos.named, c)
+        }
+        var baseRequest []string
+        if requestFile != "" {
+                var err error
+                baseRequest, err = loadBaseRequest(requestFile)
+                if err != nil {
+                        printInfo("Error: request template: "+err.Error(), nil)
+                        os.Exit(1)
+                }
+        }
+
+        if intercept {
+                if upstreamProxy == nil {
+                        printInfo("Error: --intercept requires a proxy (-x)", nil)
+                        os.Exit(1)
+                }
+                altered, err := verifyProxyIntegrity(echoAddr, time.Duration(timeoutSec*float64(time.Second)))
+                if err != nil {
+                        printInfo("Proxy check: "+ColorYellow+"unable to reach echo endpoint through proxy ("+err.Error()+")"+ColorMagenta, nil)
+                } else if len(altered) > 0 {
+                        printInfo("Proxy check: "+ColorYellow+"WARNING the proxy rewrites raw requests, results may be unreliable"+ColorMagenta, nil)
+                        for _, msg := range altered {
+                                printInfo("             "+ColorYellow+msg+ColorMagenta, nil)
+                        }
+                } else {
+                        printInfo("Proxy check: "+ColorCyan+fmt.Sprintf("%d raw payloads passed through unmodified over HTTP and HTTPS", len(integritySamples))+ColorMagenta, nil)
+                }
+        }
+
         var servers []string
-        if urlArg == "" {
+        if urlArg != "" {
+                if isCIDRTarget(urlArg) {
+                        urls, err := expandCIDR(urlArg)
+                        if err != nil {
+                                printInfo("Error: "+err.Error(), nil)
+                                os.Exit(1)
+                        }
+                        for _, u := range urls {
+                                servers = append(servers, u+" "+method)
+                        }
+                } else {
+                        servers = []string{urlArg + " " + method}
+                }
+        }
+        for _, in := range targetInputs {
+                var lines []string
+                var err error
+                switch in.kind {
+                case "targets":
+                        lines, err = readTargetFile(in.path, method)
+                case "burp":
+                        lines, err = readBurpExport(in.path)
+                case "har":
+                        lines, err = readHAR(in.path)
+                }
+                if err != nil {
+                        printInfo("Error: "+err.Error(), nil)
+                        os.Exit(1)
+                }
+                servers = append(servers, lines...)
+        }
+        if urlArg == "" && len(targetInputs) == 0 {
                 stat, _ := os.Stdin.Stat()
                 if (stat.Mode() & os.ModeCharDevice) != 0 {
                         printInfo("Error: no direct URL or piped URL specified", nil)
                         fmt.Println("Usage: smuggler -u <url> [other options]")
                         os.Exit(1)
                 }
-                scanner := bufio.NewScanner(os.Stdin)
-                for scanner.Scan() {
-                        line := scanner.Text()
-                        if strings.TrimSpace(line) != "" {
-                                servers = append(servers, line)
-                        }
+                lines, err := readTargetLines(os.Stdin, method)
+                if err != nil {
+                        printInfo("Error: "+err.Error(), nil)
+                        os.Exit(1)
+                }
+                servers = lines
+        }
+        if len(servers) > 1 {
+                before := len(servers)
+                servers = dedupeTargets(servers)
+                if before != len(servers) {
+                        printInfo(fmt.Sprintf("Targets    : %s%d%s unique (%d duplicates dropped)", ColorCyan, len(servers), ColorMagenta, before-len(servers)), nil)
                 }
-        } else {
-                servers = []string{urlArg + " " + method}
         }
 
         var logh io.Writer
@@ -707,7 +1130,10 @@ func main() {
                 defer f.Close()
                 logh = f
         }
+        // Logged so a run can be reproduced from its log alone.
+        printInfo("Seed       : "+ColorCyan+strconv.FormatInt(seed, 10)+ColorMagenta, logh)
 
+        var discovered []mutationSpec
         for _, server := range servers {
                 if strings.TrimSpace(server) == "" {
                         continue
@@ -721,26 +1147,103 @@ func main() {
                 }
                 host, port, endpoint, sslFlag := processURI(tokens[0])
                 methodUpper := strings.ToUpper(tokens[1])
+                templatePath := requestFile
+                tmplOpts.Base = baseRequest
+                if len(tokens) > 2 {
+                        // Per-target request template: "URL METHOD template_file".
+                        base, err := loadBaseRequest(tokens[2])
+                        if err != nil {
+                                printInfo("Error: request template: "+err.Error(), logh)
+                                continue
+                        }
+                        templatePath = tokens[2]
+                        tmplOpts.Base = base
+                }
+                ip := targetIP(host, port)
                 printInfo("URL        : "+ColorCyan+tokens[0], logh)
+                printInfo("IP         : "+ColorCyan+ip, logh)
                 printInfo("Method     : "+ColorCyan+methodUpper, logh)
                 printInfo("Endpoint   : "+ColorCyan+endpoint, logh)
                 printInfo("Timeout    : "+ColorCyan+fmt.Sprintf("%.1f", timeoutSec)+" "+ColorMagenta+"seconds", logh)
+                if templatePath != "" {
+                        printInfo("Template   : "+ColorCyan+templatePath, logh)
+                }
+                if sslFlag && tlsOpts.SNI != "" {
+                        printInfo("SNI        : "+ColorCyan+tlsOpts.SNI, logh)
+                }
+                if sslFlag {
+                        outcome, err := probeTLS(host, port, time.Duration(timeoutSec*float64(time.Second)))
+                        if err != nil {
+                                printInfo("TLS        : "+ColorYellow+"handshake failed ("+err.Error()+")"+ColorMagenta, logh)
+                        } else {
+                                printInfo("TLS        : "+ColorCyan+outcome, logh)
+                        }
+                }
+                if segments.Mode != "single" || segments.Delay > 0 {
+                        printInfo("Segments   : "+ColorCyan+segments.Mode+ColorMagenta+fmt.Sprintf(" (delay %s)", segments.Delay), logh)
+                }
+
+                nodes := []string{""}
+                if allIPs {
+                        var err error
+                        nodes, err = allTargetIPs(host, port)
+                        if err != nil {
+                                printInfo("Error      : "+ColorCyan+"Unable to resolve host"+ColorMagenta+" ("+err.Error()+")", logh)
+                                continue
+                        }
+                        printInfo("IPs        : "+ColorCyan+strings.Join(nodes, ", "), logh)
+                }
+
+                nodeFindings := make(map[string][]string)
+                for _, node := range nodes {
+                        sm := Desyncr{
+                                host:      host,
+                                port:      port,
+                                ip:        ip,
+                                connectIP: node,
+                                method:    methodUpper,
+                                endpoint:  endpoint,
+                                vhost:     vhost,
+                                url:       tokens[0],
+                                timeout:   time.Duration(timeoutSec * float64(time.Second)),
+                                sslFlag:   sslFlag,
+                                logh:      logh,
+                                quiet:     quiet,
+                                exitEarly: exitEarly,
+                                segments:  segments,
+                                keepAlive: keepAlive,
+                                pipelined: pipelined,
+                                fuzzCount: fuzzCount,
+                                families:  families,
+                                combos:    combos,
+                        }
+                        if node != "" {
+                                sm.ip = node
+                                printInfo("Node       : "+ColorCyan+node, logh)
+                        }
+                        if followRedirects > 0 {
+                                sm.preflight(followRedirects, retarget)
+                        }
+                        sm.run()
+                        nodeFindings[node] = sm.findings
+                        discovered = append(discovered, sm.discovered...)
+                }
+                if allIPs {
+                        printNodeSummary(nodes, nodeFindings, logh)
+                }
+        }
 
-                sm := Desyncr{
-                        host:      host,
-                        port:      port,
-                        method:    methodUpper,
-                        endpoint:  endpoint,
-                        vhost:     vhost,
-                        url:       tokens[0],
-                        timeout:   time.Duration(timeoutSec * float64(time.Second)),
-                        sslFlag:   sslFlag,
-                        logh:      logh,
-                        quiet:     quiet,
-                        exitEarly: exitEarly,
-                        cookies:   []string{},
-                }
-                sm.run()
+        if fuzzCount > 0 {
+                // Every target and node reports the gadgets it reacted to.
+                discovered = dedupeSpecs(discovered)
+                printInfo(fmt.Sprintf("Fuzzing    : %s%d%s differential gadgets found", ColorCyan, len(discovered), ColorMagenta), logh)
+                if fuzzOut != "" && len(discovered) > 0 {
+                        if err := writeMutationFile(fuzzOut, discovered); err != nil {
+                                printInfo("Error: "+err.Error(), logh)
+                        } else {
+                                printInfo("Exported   : "+ColorCyan+fuzzOut+ColorMagenta+" (use with --mutations)", logh)
+                        }
+                }
         }
 
         if logh != nil {
@@ -0,0 +1,238 @@
+package main
+
+import (
+        "bufio"
+        "encoding/json"
+        "encoding/xml"
+        "fmt"
+        "io"
+        "net"
+        "net/url"
+        "os"
+        "strconv"
+        "strings"
+)
+
+// ------------------------------
+// Target list inputs
+
+// maxCIDRHosts caps how many addresses a single CIDR entry may expand to.
+const maxCIDRHosts = 65536
+
+// readTargetLines reads "URL [METHOD [template]]" lines, skipping blanks and
+// # comments. CIDR entries are expanded in place.
+func readTargetLines(r io.Reader, method string) ([]string, error) {
+        var servers []string
+        scanner := bufio.NewScanner(r)
+        for scanner.Scan() {
+                line := strings.TrimSpace(scanner.Text())
+                if line == "" || strings.HasPrefix(line, "#") {
+                        continue
+                }
+                tokens := strings.Fields(line)
+                if !isCIDRTarget(tokens[0]) {
+                        servers = append(servers, line)
+                        continue
+                }
+                urls, err := expandCIDR(tokens[0])
+                if err != nil {
+                        return nil, err
+                }
+                rest := method
+                if len(tokens) > 1 {
+                        rest = strings.Join(tokens[1:], " ")
+                }
+                for _, u := range urls {
+                        servers = append(servers, u+" "+rest)
+                }
+        }
+        return servers, scanner.Err()
+}
+
+// readTargetFile reads a --targets file.
+func readTargetFile(path, method string) ([]string, error) {
+        f, err := os.Open(path)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+        return readTargetLines(f, method)
+}
+
+// isCIDRTarget reports whether s is an address range such as
+// "10.0.0.0/28:443" rather than a URL with a path. The part before any port
+// list must parse as a CIDR. With a scheme a port list is also required,
+// since "https://10.0.0.5/24" is a valid URL as it stands.
+func isCIDRTarget(s string) bool {
+        rest := strings.TrimPrefix(strings.TrimPrefix(s, "http://"), "https://")
+        slash := strings.Index(rest, "/")
+        if slash < 0 {
+                return false
+        }
+        cidr, hasPorts := rest, false
+        if colon := strings.Index(rest[slash:], ":"); colon >= 0 {
+                cidr, hasPorts = rest[:slash+colon], true
+        }
+        if _, _, err := net.ParseCIDR(cidr); err != nil {
+                return false
+        }
+        return rest == s || hasPorts
+}
+
+// expandCIDR turns "[scheme://]10.0.0.0/28[:port[,port]]" into one URL per
+// address and port. Without a scheme port 80 is http and anything else https;
+// without a port 443 is used.
+func expandCIDR(spec string) ([]string, error) {
+        scheme := ""
+        if idx := strings.Index(spec, "://"); idx >= 0 {
+                scheme, spec = spec[:idx], spec[idx+3:]
+        }
+        cidr, portList := spec, ""
+        if slash := strings.Index(spec, "/"); slash >= 0 {
+                if colon := strings.Index(spec[slash:], ":"); colon >= 0 {
+                        cidr, portList = spec[:slash+colon], spec[slash+colon+1:]
+                }
+        }
+        ip, network, err := net.ParseCIDR(cidr)
+        if err != nil {
+                return nil, err
+        }
+        ones, bits := network.Mask.Size()
+        if bits-ones > 16 {
+                return nil, fmt.Errorf("%s expands to more than %d addresses", cidr, maxCIDRHosts)
+        }
+        ports := []int{443}
+        if portList != "" {
+                ports = nil
+                for _, p := range strings.Split(portList, ",") {
+                        port, err := strconv.Atoi(p)
+                        if err != nil || port < 1 || port > 65535 {
+                                return nil, fmt.Errorf("invalid port in %s: %s", spec, p)
+                        }
+                        ports = append(ports, port)
+                }
+        }
+
+        var urls []string
+        for cur := ip.Mask(network.Mask); network.Contains(cur); cur = nextIP(cur) {
+                for _, port := range ports {
+                        s := scheme
+                        if s == "" {
+                                s = "https"
+                                if port == 80 {
+                                        s = "http"
+                                }
+                        }
+                        urls = append(urls, fmt.Sprintf("%s://%s", s, net.JoinHostPort(cur.String(), strconv.Itoa(port))))
+                }
+        }
+        return urls, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+        next := append(net.IP(nil), ip...)
+        for i := len(next) - 1; i >= 0; i-- {
+                next[i]++
+                if next[i] != 0 {
+                        break
+                }
+        }
+        return next
+}
+
+// burpItems mirrors the parts of a Burp Suite "Save items" XML export we use.
+type burpItems struct {
+        Items []struct {
+                URL    string `xml:"url"`
+                Method string `xml:"method"`
+        } `xml:"item"`
+}
+
+// readBurpExport returns one target line per item in a Burp XML export.
+func readBurpExport(path string) ([]string, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, err
+        }
+        var items burpItems
+        if err := xml.Unmarshal(data, &items); err != nil {
+                return nil, fmt.Errorf("%s: %v", path, err)
+        }
+        var servers []string
+        for _, item := range items.Items {
+                if item.URL != "" {
+                        servers = append(servers, strings.TrimSpace(item.URL)+" "+item.Method)
+                }
+        }
+        return servers, nil
+}
+
+// harLog mirrors the parts of an HTTP Archive (HAR 1.2) file we use.
+type harLog struct {
+        Log struct {
+                Entries []struct {
+                        Request struct {
+                                Method string `json:"method"`
+                                URL    string `json:"url"`
+                        } `json:"request"`
+                } `json:"entries"`
+        } `json:"log"`
+}
+
+// readHAR returns one target line per request in a HAR file, as exported by
+// browsers and ZAP.
+func readHAR(path string) ([]string, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, err
+        }
+        var har harLog
+        if err := json.Unmarshal(data, &har); err != nil {
+                return nil, fmt.Errorf("%s: %v", path, err)
+        }
+        var servers []string
+        for _, entry := range har.Log.Entries {
+                if entry.Request.URL != "" {
+                        servers = append(servers, entry.Request.URL+" "+entry.Request.Method)
+                }
+        }
+        return servers, nil
+}
+
+// dedupeTargets drops lines whose URL matches an earlier one on scheme,
+// host, port and path. Lines that don't parse are kept as they are.
+func dedupeTargets(servers []string) []string {
+        seen := make(map[string]bool)
+        var out []string
+        for _, server := range servers {
+                tokens := strings.Fields(server)
+                if len(tokens) == 0 {
+                        continue
+                }
+                raw := tokens[0]
+                if !strings.HasPrefix(strings.ToLower(raw), "http") {
+                        raw = "https://" + raw
+                }
+                u, err := url.Parse(raw)
+                if err != nil {
+                        out = append(out, server)
+                        continue
+                }
+                host, port, _, sslFlag, ok := splitURL(u)
+                if !ok {
+                        out = append(out, server)
+                        continue
+                }
+                path := u.Path
+                if path == "" {
+                        path = "/"
+                }
+                key := fmt.Sprintf("%t|%s|%d|%s", sslFlag, strings.ToLower(host), port, path)
+                if seen[key] {
+                        continue
+                }
+                seen[key] = true
+                out = append(out, server)
+        }
+        return out
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+        "os"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestExpandCIDR(t *testing.T) {
+        tests := []struct {
+                spec    string
+                want    []string // nil when only count is checked
+                count   int
+                wantErr string
+        }{
+                {spec: "10.0.0.0/30", want: []string{"https://10.0.0.0:443", "https://10.0.0.1:443", "https://10.0.0.2:443", "https://10.0.0.3:443"}},
+                {spec: "10.0.0.5/31:80,8443", want: []string{"http://10.0.0.4:80", "https://10.0.0.4:8443", "http://10.0.0.5:80", "https://10.0.0.5:8443"}},
+                {spec: "http://10.0.0.9/32:8080", want: []string{"http://10.0.0.9:8080"}},
+                {spec: "255.255.255.254/31", want: []string{"https://255.255.255.254:443", "https://255.255.255.255:443"}},
+                {spec: "2001:db8::/127:80", want: []string{"http://[2001:db8::]:80", "http://[2001:db8::1]:80"}},
+                {spec: "10.0.0.0/16", count: maxCIDRHosts},
+                {spec: "10.0.0.0/15", wantErr: "more than 65536 addresses"},
+                {spec: "2001:db8::/112", count: maxCIDRHosts},
+                {spec: "2001:db8::/111", wantErr: "more than 65536 addresses"},
+                {spec: "10.0.0.0/30:0", wantErr: "invalid port"},
+                {spec: "10.0.0.0/30:http", wantErr: "invalid port"},
+                {spec: "10.0.0.0/33", wantErr: "invalid CIDR"},
+                {spec: "https://10.0.0.5/42", wantErr: "invalid CIDR"},
+        }
+        for _, tt := range tests {
+                got, err := expandCIDR(tt.spec)
+                switch {
+                case tt.wantErr != "":
+                        if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                                t.Errorf("%s: got error %v, want %q", tt.spec, err, tt.wantErr)
+                        }
+                case err != nil:
+                        t.Errorf("%s: %v", tt.spec, err)
+                case tt.want != nil && !reflect.DeepEqual(got, tt.want):
+                        t.Errorf("%s: got %q, want %q", tt.spec, got, tt.want)
+                case tt.want == nil && len(got) != tt.count:
+                        t.Errorf("%s: got %d URLs, want %d", tt.spec, len(got), tt.count)
+                }
+        }
+}
+
+func TestIsCIDRTarget(t *testing.T) {
+        tests := []struct {
+                s    string
+                want bool
+        }{
+                {"10.0.0.0/28", true},
+                {"10.0.0.0/28:80,443", true},
+                {"2001:db8::/120:443", true},
+                {"http://10.0.0.0/28:8080", true},
+                {"https://10.0.0.5/42", false},
+                {"https://10.0.0.5/24", false},
+                {"10.0.0.5/42", false},
+                {"10.0.0.5/admin", false},
+                {"https://a.test/24", false},
+                {"10.0.0.5", false},
+        }
+        for _, tt := range tests {
+                if got := isCIDRTarget(tt.s); got != tt.want {
+                        t.Errorf("isCIDRTarget(%q): got %v, want %v", tt.s, got, tt.want)
+                }
+        }
+}
+
+func TestReadTargetLines(t *testing.T) {
+        input := "# comment\n\nhttps://a.test/x POST\nhttps://10.0.0.5/42\n10.0.0.0/31:80\n10.0.0.8/31 PUT tmpl\n"
+        got, err := readTargetLines(strings.NewReader(input), "GET")
+        if err != nil {
+                t.Fatal(err)
+        }
+        want := []string{
+                "https://a.test/x POST",
+                "https://10.0.0.5/42",
+                "http://10.0.0.0:80 GET",
+                "http://10.0.0.1:80 GET",
+                "https://10.0.0.8:443 PUT tmpl",
+                "https://10.0.0.9:443 PUT tmpl",
+        }
+        if !reflect.DeepEqual(got, want) {
+                t.Errorf("got %q, want %q", got, want)
+        }
+}
+
+// writeTemp writes data to a file in the test's temporary directory.
+func writeTemp(t *testing.T, name, data string) string {
+        path := filepath.Join(t.TempDir(), name)
+        if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+                t.Fatal(err)
+        }
+        return path
+}
+
+func TestReadBurpExport(t *testing.T) {
+        path := writeTemp(t, "items.xml", `<?xml version="1.0"?>
+<items burpVersion="2023.1">
+  <item><url><![CDATA[https://a.test/login]]></url><method><![CDATA[POST]]></method></item>
+  <item><url>
+    http://b.test/
+  </url></item>
+  <item><url></url><method>GET</method></item>
+</items>`)
+        got, err := readBurpExport(path)
+        if err != nil {
+                t.Fatal(err)
+        }
+        want := []string{"https://a.test/login POST", "http://b.test/ "}
+        if !reflect.DeepEqual(got, want) {
+                t.Errorf("got %q, want %q", got, want)
+        }
+
+        if _, err := readBurpExport(writeTemp(t, "bad.xml", "<items><item>")); err == nil {
+                t.Errorf("truncated export accepted")
+        }
+}
+
+func TestReadHAR(t *testing.T) {
+        path := writeTemp(t, "session.har", `{"log": {"version": "1.2", "entries": [
+  {"request": {"method": "GET", "url": "https://a.test/"}},
+  {"request": {"method": "POST", "url": "https://a.test/api?x=1"}},
+  {"request": {"method": "GET", "url": ""}}
+]}}`)
+        got, err := readHAR(path)
+        if err != nil {
+                t.Fatal(err)
+        }
+        want := []string{"https://a.test/ GET", "https://a.test/api?x=1 POST"}
+        if !reflect.DeepEqual(got, want) {
+                t.Errorf("got %q, want %q", got, want)
+        }
+
+        if _, err := readHAR(writeTemp(t, "bad.har", `{"log": [`)); err == nil {
+                t.Errorf("truncated HAR accepted")
+        }
+}
+
+func TestDedupeTargets(t *testing.T) {
+        tests := []struct {
+                name    string
+                servers []string
+                want    []string
+        }{
+                {"default port", []string{"http://a.test/x", "http://a.test:80/x POST"}, []string{"http://a.test/x"}},
+                {"host case", []string{"https://A.test/x", "https://a.TEST/x"}, []string{"https://A.test/x"}},
+                {"implicit scheme", []string{"https://a.test/x", "a.test/x GET"}, []string{"https://a.test/x"}},
+                {"empty path", []string{"https://a.test", "https://a.test:443/"}, []string{"https://a.test"}},
+                {"query ignored", []string{"https://a.test/x?a=1", "https://a.test/x?a=2"}, []string{"https://a.test/x?a=1"}},
+                {"scheme differs", []string{"http://a.test:8080/", "https://a.test:8080/"}, []string{"http://a.test:8080/", "https://a.test:8080/"}},
+                {"port differs", []string{"https://a.test/", "https://a.test:8443/"}, []string{"https://a.test/", "https://a.test:8443/"}},
+                {"path case", []string{"https://a.test/x", "https://a.test/X"}, []string{"https://a.test/x", "https://a.test/X"}},
+                {"unparsable kept", []string{"http://[bad/", "http://[bad/"}, []string{"http://[bad/", "http://[bad/"}},
+        }
+        for _, tt := range tests {
+                if got := dedupeTargets(tt.servers); !reflect.DeepEqual(got, tt.want) {
+                        t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+                }
+        }
+}
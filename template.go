@@ -0,0 +1,182 @@
+package main
+
+import (
+        "encoding/base64"
+        "fmt"
+        "os"
+        "strings"
+)
+
+// ------------------------------
+// Base request template
+
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/78.0.3904.87 Safari/537.36"
+
+// templateOptions customises the request every gadget is rendered into.
+type templateOptions struct {
+        UserAgent string   // replaces the default User-Agent when set
+        Headers   []string // extra "Name: value" lines from -H and the auth options
+        Base      []string // request line and headers of a raw base request, if any
+
+        CacheBuster     string // "query" (default), "header" or "none"
+        CacheBusterName string // parameter or header name, defaults per mode
+}
+
+// tmplOpts is set from the command line. A per-target template only swaps
+// Base for the duration of that target's scan.
+var tmplOpts templateOptions
+
+// parseHeaderArg validates a -H value.
+func parseHeaderArg(h string) (string, error) {
+        idx := strings.Index(h, ":")
+        if idx <= 0 {
+                return "", fmt.Errorf("invalid header %q, want 'Name: value'", h)
+        }
+        return strings.TrimSpace(h[:idx]) + ": " + strings.TrimSpace(h[idx+1:]), nil
+}
+
+func basicAuthHeader(userPass string) string {
+        return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(userPass))
+}
+
+func bearerAuthHeader(token string) string {
+        return "Authorization: Bearer " + token
+}
+
+// loadBaseRequest reads a raw request file and returns its request line and
+// header lines. Any body is ignored, the gadget body is added per check.
+func loadBaseRequest(path string) ([]string, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, err
+        }
+        var lines []string
+        for _, line := range strings.Split(string(data), "\n") {
+                line = strings.TrimSuffix(line, "\r")
+                if line == "" {
+                        if len(lines) == 0 {
+                                continue
+                        }
+                        break
+                }
+                lines = append(lines, line)
+        }
+        if len(lines) == 0 {
+                return nil, fmt.Errorf("no request line in %s", path)
+        }
+        return lines, nil
+}
+
+func headerName(line string) string {
+        if idx := strings.Index(line, ":"); idx >= 0 {
+                return strings.ToLower(strings.TrimSpace(line[:idx]))
+        }
+        return ""
+}
+
+// header builds the header block for gadget. Framing headers from a base
+// request are dropped, the gadget follows the request line and
+// Content-Length always comes last.
+func (o *templateOptions) header(gadget string) string {
+        RN := "\r\n"
+        base := o.Base
+        if base == nil {
+                base = []string{
+                        "__METHOD__ __ENDPOINT__ HTTP/1.1",
+                        "Host: __HOST__",
+                        "User-Agent: " + defaultUserAgent,
+                        "Content-type: application/x-www-form-urlencoded; charset=UTF-8",
+                }
+        }
+        override := make(map[string]bool)
+        for _, h := range o.Headers {
+                override[headerName(h)] = true
+        }
+
+        requestLine := base[0]
+        if !strings.Contains(requestLine, "__ENDPOINT__") {
+                // The request target is given verbatim, so the query
+                // cache-buster has to be merged in here rather than at render.
+                if parts := strings.SplitN(requestLine, " ", 3); len(parts) == 3 {
+                        parts[1] = addQueryParam(parts[1], o.queryCacheBuster(), "__RANDOM__")
+                        requestLine = strings.Join(parts, " ")
+                }
+        }
+
+        var b strings.Builder
+        b.WriteString(requestLine + RN)
+        if gadget != "" {
+                b.WriteString(gadget + RN)
+        }
+        for _, line := range base[1:] {
+                name := headerName(line)
+                switch {
+                case name == "content-length", name == "transfer-encoding", override[name]:
+                        continue
+                case name == "user-agent" && o.UserAgent != "":
+                        line = "User-Agent: " + o.UserAgent
+                }
+                b.WriteString(line + RN)
+        }
+        for _, h := range o.Headers {
+                b.WriteString(h + RN)
+        }
+        if o.CacheBuster == "header" {
+                name := o.CacheBusterName
+                if name == "" {
+                        name = "X-Cache-Buster"
+                }
+                b.WriteString(name + ": __RANDOM__" + RN)
+        }
+        b.WriteString("Content-Length: __REPLACE_CL__" + RN)
+        return b.String()
+}
+
+// withBaseTarget fills the __ENDPOINT__ of a request-line mutation with the
+// target of the template's request line when the base request gives it
+// verbatim, so the mutation does not send the check to a different path.
+func withBaseTarget(line, templateLine string) string {
+        if strings.Contains(templateLine, "__ENDPOINT__") {
+                return line
+        }
+        parts := strings.SplitN(templateLine, " ", 3)
+        if len(parts) != 3 {
+                return line
+        }
+        return strings.ReplaceAll(line, "__ENDPOINT__", parts[1])
+}
+
+// queryCacheBuster returns the query parameter used as cache-buster, or ""
+// when the cache-buster is not placed in the query.
+func (o *templateOptions) queryCacheBuster() string {
+        if o.CacheBuster != "" && o.CacheBuster != "query" {
+                return ""
+        }
+        if o.CacheBusterName == "" {
+                return "cb"
+        }
+        return o.CacheBusterName
+}
+
+// addQueryParam sets name=value in the request target's query, keeping the
+// other parameters. An existing name parameter, such as a cache-buster echoed
+// back in a redirect, is replaced rather than repeated. An empty name leaves
+// target unchanged.
+func addQueryParam(target, name, value string) string {
+        if name == "" {
+                return target
+        }
+        path, query, found := strings.Cut(target, "?")
+        if !found {
+                return target + "?" + name + "=" + value
+        }
+        var params []string
+        for _, param := range strings.Split(query, "&") {
+                if param == "" || param == name || strings.HasPrefix(param, name+"=") {
+                        continue
+                }
+                params = append(params, param)
+        }
+        params = append(params, name+"="+value)
+        return path + "?" + strings.Join(params, "&")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+        "strings"
+        "testing"
+)
+
+func TestTemplateHeader(t *testing.T) {
+        base := []string{
+                "POST /login?next=/ HTTP/1.1",
+                "Host: __HOST__",
+                "Content-Length: 42",
+                "User-Agent: curl/8.0",
+                "Cookie: old=1",
+                "Transfer-Encoding: chunked",
+                "Accept: */*",
+        }
+        tests := []struct {
+                name string
+                opts templateOptions
+                want []string
+        }{
+                {"default", templateOptions{}, []string{
+                        "__METHOD__ __ENDPOINT__ HTTP/1.1",
+                        "GADGET",
+                        "Host: __HOST__",
+                        "User-Agent: " + defaultUserAgent,
+                        "Content-type: application/x-www-form-urlencoded; charset=UTF-8",
+                        "Content-Length: __REPLACE_CL__",
+                }},
+                {"base framing dropped", templateOptions{Base: base, CacheBuster: "none"}, []string{
+                        "POST /login?next=/ HTTP/1.1",
+                        "GADGET",
+                        "Host: __HOST__",
+                        "User-Agent: curl/8.0",
+                        "Cookie: old=1",
+                        "Accept: */*",
+                        "Content-Length: __REPLACE_CL__",
+                }},
+                {"overrides", templateOptions{Base: base, UserAgent: "ua/1", Headers: []string{"Cookie: new=2", "X-Extra: y"}, CacheBuster: "header"}, []string{
+                        "POST /login?next=/ HTTP/1.1",
+                        "GADGET",
+                        "Host: __HOST__",
+                        "User-Agent: ua/1",
+                        "Accept: */*",
+                        "Cookie: new=2",
+                        "X-Extra: y",
+                        "X-Cache-Buster: __RANDOM__",
+                        "Content-Length: __REPLACE_CL__",
+                }},
+                {"query cache-buster", templateOptions{Base: base[:2]}, []string{
+                        "POST /login?next=/&cb=__RANDOM__ HTTP/1.1",
+                        "GADGET",
+                        "Host: __HOST__",
+                        "Content-Length: __REPLACE_CL__",
+                }},
+        }
+        for _, tt := range tests {
+                got := tt.opts.header("GADGET")
+                want := strings.Join(tt.want, "\r\n") + "\r\n"
+                if got != want {
+                        t.Errorf("%s:\n got %q\nwant %q", tt.name, got, want)
+                }
+        }
+}
+
+// TestRequestLineKeepsBaseTarget checks a request-line mutation keeps the
+// verbatim target of a --base-request instead of reverting to "/".
+func TestRequestLineKeepsBaseTarget(t *testing.T) {
+        fixedRandom(t)
+        opts := templateOptions{Base: []string{"POST /api/v2 HTTP/1.1", "Host: __HOST__"}}
+        p := &Payload{Header: opts.header(""), Method: "GET", Endpoint: "/", Host: "example.com", CL: -1, CB: opts.queryCacheBuster()}
+        for name, want := range map[string]string{
+                "http10":   "GET /api/v2?cb=123456 HTTP/1.0\r\n",
+                "absolute": "GET http://example.com/api/v2?cb=123456 HTTP/1.1\r\n",
+        } {
+                p.RequestLine = requestLines()[name]
+                if got := p.String(); !strings.HasPrefix(got, want) {
+                        t.Errorf("%s: got %q, want prefix %q", name, got, want)
+                }
+        }
+
+        p.Header = (&templateOptions{}).header("")
+        p.RequestLine = requestLines()["http10"]
+        if got := p.String(); !strings.HasPrefix(got, "GET /?cb=123456 HTTP/1.0\r\n") {
+                t.Errorf("default template: got %q", got)
+        }
+}
+
+func TestQueryCacheBuster(t *testing.T) {
+        tests := []struct {
+                opts templateOptions
+                want string
+        }{
+                {templateOptions{}, "cb"},
+                {templateOptions{CacheBuster: "query"}, "cb"},
+                {templateOptions{CacheBuster: "query", CacheBusterName: "nocache"}, "nocache"},
+                {templateOptions{CacheBusterName: "nocache"}, "nocache"},
+                {templateOptions{CacheBuster: "header"}, ""},
+                {templateOptions{CacheBuster: "none", CacheBusterName: "nocache"}, ""},
+        }
+        for _, tt := range tests {
+                if got := tt.opts.queryCacheBuster(); got != tt.want {
+                        t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+                }
+        }
+}
+
+func TestAddQueryParam(t *testing.T) {
+        tests := []struct {
+                target, name, want string
+        }{
+                {"/", "cb", "/?cb=V"},
+                {"/x?", "cb", "/x?cb=V"},
+                {"/x?a=1", "cb", "/x?a=1&cb=V"},
+                {"/x?a=1&", "cb", "/x?a=1&cb=V"},
+                {"/x?cb=123", "cb", "/x?cb=V"},
+                {"/x?cb=1&a=2&cb=3", "cb", "/x?a=2&cb=V"},
+                {"/x?cb", "cb", "/x?cb=V"},
+                {"/x?cbx=1", "cb", "/x?cbx=1&cb=V"},
+                {"/x?a=1", "", "/x?a=1"},
+        }
+        for _, tt := range tests {
+                if got := addQueryParam(tt.target, tt.name, "V"); got != tt.want {
+                        t.Errorf("addQueryParam(%q, %q): got %q, want %q", tt.target, tt.name, got, tt.want)
+                }
+        }
+        // A redirect that echoes the cache-buster must not make it pile up
+        // once the scan is retargeted.
+        fixedRandom(t)
+        p := renderTemplate("")
+        p.Host, p.Endpoint = "example.com", "/next?cb=987654"
+        if got := p.String(); !strings.HasPrefix(got, "GET /next?cb=123456 HTTP/1.1\r\n") {
+                t.Errorf("retargeted endpoint: got %q", got)
+        }
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+        "crypto/tls"
+        "crypto/x509"
+        "fmt"
+        "os"
+        "time"
+)
+
+// ------------------------------
+// TLS configuration
+
+// tlsOptions holds the TLS settings given on the command line.
+type tlsOptions struct {
+        SNI        string // overrides the server name sent, default is the target host
+        Verify     bool   // verify the server certificate chain and name
+        CAFile     string // PEM bundle used instead of the system roots
+        CertFile   string // client certificate for mutual TLS
+        KeyFile    string
+        MinVersion uint16
+        MaxVersion uint16
+        ALPN       []string // protocols offered, none by default
+
+        roots *x509.CertPool
+        certs []tls.Certificate
+}
+
+var tlsOpts tlsOptions
+
+var tlsVersions = map[string]uint16{
+        "1.0": tls.VersionTLS10,
+        "1.1": tls.VersionTLS11,
+        "1.2": tls.VersionTLS12,
+        "1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion maps "1.0" to "1.3" onto the crypto/tls constants.
+func parseTLSVersion(v string) (uint16, error) {
+        if ver, ok := tlsVersions[v]; ok {
+                return ver, nil
+        }
+        return 0, fmt.Errorf("unknown TLS version: %s (use 1.0, 1.1, 1.2 or 1.3)", v)
+}
+
+// load reads the CA bundle and client key pair, if any. It must be called
+// once after flag parsing and before the first connection.
+func (o *tlsOptions) load() error {
+        if o.CAFile != "" {
+                pem, err := os.ReadFile(o.CAFile)
+                if err != nil {
+                        return err
+                }
+                o.roots = x509.NewCertPool()
+                if !o.roots.AppendCertsFromPEM(pem) {
+                        return fmt.Errorf("no certificates found in %s", o.CAFile)
+                }
+        }
+        if o.CertFile != "" || o.KeyFile != "" {
+                if o.CertFile == "" || o.KeyFile == "" {
+                        return fmt.Errorf("both --client-cert and --client-key are required")
+                }
+                cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+                if err != nil {
+                        return err
+                }
+                o.certs = []tls.Certificate{cert}
+        }
+        for _, proto := range o.ALPN {
+                if proto == "h2" {
+                        return fmt.Errorf("--alpn h2 is not supported, payloads are raw HTTP/1.1")
+                }
+        }
+        if o.MinVersion != 0 && o.MaxVersion != 0 && o.MinVersion > o.MaxVersion {
+                return fmt.Errorf("--tls-min is greater than --tls-max")
+        }
+        return nil
+}
+
+// config returns the client configuration for a connection to host.
+func (o *tlsOptions) config(host string) *tls.Config {
+        serverName := host
+        if o.SNI != "" {
+                serverName = o.SNI
+        }
+        config := &tls.Config{
+                ServerName:         serverName,
+                InsecureSkipVerify: !o.Verify,
+                RootCAs:            o.roots,
+                Certificates:       o.certs,
+                MinVersion:         o.MinVersion,
+                MaxVersion:         o.MaxVersion,
+                NextProtos:         o.ALPN,
+        }
+        return config
+}
+
+// ------------------------------
+// Handshake report
+
+// probeTLS performs one handshake with the configured options and returns a
+// short description of the outcome.
+func probeTLS(host string, port int, timeout time.Duration) (string, error) {
+        conn, err := easySSLConnect(host, port, timeout, true)
+        if err != nil {
+                return "", err
+        }
+        state := conn.(*tls.Conn).ConnectionState()
+        conn.Close()
+        alpn := state.NegotiatedProtocol
+        if alpn == "" {
+                alpn = "none"
+        }
+        return fmt.Sprintf("%s %s alpn=%s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), alpn), nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+        "bufio"
+        "crypto/ecdsa"
+        "crypto/elliptic"
+        "crypto/rand"
+        "crypto/tls"
+        "crypto/x509"
+        "crypto/x509/pkix"
+        "encoding/pem"
+        "io"
+        "math/big"
+        "net"
+        "net/http"
+        "net/http/httptest"
+        "strconv"
+        "strings"
+        "testing"
+        "time"
+)
+
+func TestTLSOptionsLoad(t *testing.T) {
+        tests := []struct {
+                opts tlsOptions
+                err  string
+        }{
+                {tlsOptions{}, ""},
+                {tlsOptions{ALPN: []string{"http/1.1"}}, ""},
+                {tlsOptions{ALPN: []string{"http/1.1", "h2"}}, "h2 is not supported"},
+                {tlsOptions{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}, "greater than"},
+        }
+        for _, tt := range tests {
+                err := tt.opts.load()
+                if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+                        t.Errorf("%+v: got error %v, want %q", tt.opts, err, tt.err)
+                }
+        }
+}
+
+// TestConnectRefusesH2 checks a connection that ends up speaking h2 is
+// closed rather than used for raw HTTP/1.1 payloads.
+func TestConnectRefusesH2(t *testing.T) {
+        srv := httptest.NewUnstartedServer(nil)
+        srv.EnableHTTP2 = true
+        srv.StartTLS()
+        defer srv.Close()
+        host, portStr, _ := net.SplitHostPort(srv.Listener.Addr().String())
+        port, _ := strconv.Atoi(portStr)
+
+        saved := tlsOpts
+        t.Cleanup(func() { tlsOpts = saved })
+        tlsOpts = tlsOptions{ALPN: []string{"h2"}}
+        if _, err := easySSLConnect(host, port, time.Second, true); err == nil || !strings.Contains(err.Error(), "h2") {
+                t.Errorf("h2 connection: got error %v", err)
+        }
+        tlsOpts = tlsOptions{ALPN: []string{"http/1.1"}}
+        conn, err := easySSLConnect(host, port, time.Second, true)
+        if err != nil {
+                t.Fatalf("http/1.1 connection: %v", err)
+        }
+        conn.Close()
+}
+
+// useTLSOptions loads opts into tlsOpts for one test.
+func useTLSOptions(t *testing.T, opts tlsOptions) {
+        saved := tlsOpts
+        t.Cleanup(func() { tlsOpts = saved })
+        if err := opts.load(); err != nil {
+                t.Fatal(err)
+        }
+        tlsOpts = opts
+}
+
+// startTLSServer starts an HTTPS test server with the given configuration
+// and returns it with its host and port.
+func startTLSServer(t *testing.T, config *tls.Config) (*httptest.Server, string, int) {
+        srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+        srv.TLS = config
+        srv.StartTLS()
+        t.Cleanup(srv.Close)
+        host, portStr, _ := net.SplitHostPort(srv.Listener.Addr().String())
+        port, _ := strconv.Atoi(portStr)
+        return srv, host, port
+}
+
+// writeCertPEM writes a PEM certificate to a temporary file.
+func writeCertPEM(t *testing.T, name string, cert *x509.Certificate) string {
+        return writeTemp(t, name, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+}
+
+// newClientPair creates a self-signed client certificate, writes the pair
+// to temporary files and returns the certificate with the two paths.
+func newClientPair(t *testing.T, name string) (*x509.Certificate, string, string) {
+        key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+        if err != nil {
+                t.Fatal(err)
+        }
+        tmpl := &x509.Certificate{
+                SerialNumber:          big.NewInt(1),
+                Subject:               pkix.Name{CommonName: name},
+                NotBefore:             time.Now().Add(-time.Hour),
+                NotAfter:              time.Now().Add(time.Hour),
+                KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+                ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+                BasicConstraintsValid: true,
+                IsCA:                  true,
+        }
+        der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+        if err != nil {
+                t.Fatal(err)
+        }
+        cert, _ := x509.ParseCertificate(der)
+        keyDER, err := x509.MarshalECPrivateKey(key)
+        if err != nil {
+                t.Fatal(err)
+        }
+        keyPath := writeTemp(t, name+".key", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+        return cert, writeCertPEM(t, name+".crt", cert), keyPath
+}
+
+// roundTrip sends a request over a fresh connection and reports whether a
+// response came back. With TLS 1.3 a rejected client certificate only
+// shows up once the client reads.
+func roundTrip(host string, port int) error {
+        conn, err := easySSLConnect(host, port, 2*time.Second, true)
+        if err != nil {
+                return err
+        }
+        defer conn.Close()
+        if _, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+host+"\r\n\r\n"); err != nil {
+                return err
+        }
+        _, _, _, err = readResponse(bufio.NewReader(conn), "GET")
+        return err
+}
+
+// TestTLSConfigSNI checks --sni is what the server sees while the
+// connection still goes to the target address.
+func TestTLSConfigSNI(t *testing.T) {
+        seen := make(chan string, 2)
+        _, host, port := startTLSServer(t, &tls.Config{
+                GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+                        seen <- hello.ServerName
+                        return nil, nil
+                },
+        })
+
+        useTLSOptions(t, tlsOptions{SNI: "www.app.test"})
+        if err := roundTrip(host, port); err != nil {
+                t.Fatal(err)
+        }
+        if got := <-seen; got != "www.app.test" {
+                t.Errorf("got server name %q, want www.app.test", got)
+        }
+
+        // Without --sni an IP target sends no server name at all.
+        useTLSOptions(t, tlsOptions{})
+        if err := roundTrip(host, port); err != nil {
+                t.Fatal(err)
+        }
+        if got := <-seen; got != "" {
+                t.Errorf("got server name %q for an IP target, want none", got)
+        }
+}
+
+// TestTLSConfigVerify checks verification uses --ca-file instead of the
+// system roots and checks the name against --sni.
+func TestTLSConfigVerify(t *testing.T) {
+        srv, host, port := startTLSServer(t, nil)
+        ca := writeCertPEM(t, "ca.pem", srv.Certificate())
+
+        tests := []struct {
+                opts tlsOptions
+                err  string
+        }{
+                {tlsOptions{}, ""},
+                {tlsOptions{Verify: true, SNI: "example.com"}, "unknown authority"},
+                {tlsOptions{Verify: true, SNI: "example.com", CAFile: ca}, ""},
+                {tlsOptions{Verify: true, CAFile: ca}, ""},
+                {tlsOptions{Verify: true, SNI: "other.test", CAFile: ca}, "other.test"},
+        }
+        for _, tt := range tests {
+                useTLSOptions(t, tt.opts)
+                err := roundTrip(host, port)
+                if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+                        t.Errorf("%+v: got error %v, want %q", tt.opts, err, tt.err)
+                }
+        }
+}
+
+// TestTLSConfigClientCert checks a server requiring client certificates
+// accepts the configured pair and nothing else.
+func TestTLSConfigClientCert(t *testing.T) {
+        trusted, certFile, keyFile := newClientPair(t, "trusted")
+        _, strangerCert, strangerKey := newClientPair(t, "stranger")
+        pool := x509.NewCertPool()
+        pool.AddCert(trusted)
+        _, host, port := startTLSServer(t, &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool})
+
+        tests := []struct {
+                opts tlsOptions
+                ok   bool
+        }{
+                {tlsOptions{CertFile: certFile, KeyFile: keyFile}, true},
+                {tlsOptions{CertFile: strangerCert, KeyFile: strangerKey}, false},
+                {tlsOptions{}, false},
+        }
+        for _, tt := range tests {
+                useTLSOptions(t, tt.opts)
+                if err := roundTrip(host, port); (err == nil) != tt.ok {
+                        t.Errorf("%+v: got error %v", tt.opts, err)
+                }
+        }
+
+        if err := (&tlsOptions{CertFile: certFile}).load(); err == nil {
+                t.Errorf("--client-cert without --client-key accepted")
+        }
+}